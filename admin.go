@@ -0,0 +1,300 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Subject recorded as received on the transitions forced through the
+// admin api
+const adminTransitionSubject = "admin.transition"
+
+// ErrInvalidTransition : returned when a service can't be moved with the
+// requested event
+var ErrInvalidTransition = errors.New("Service can't be moved with the given event")
+
+// serviceSummary : a service as listed by the admin api
+type serviceSummary struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// adminHandler : serves the admin api under /services
+//
+//	GET  /services                    in flight services, all with ?all=true
+//	GET  /services/:id                service document and workflow
+//	GET  /services/:id/history        transition history, from offset up to limit
+//	GET  /services/:id/graph          workflow graph, ?format=dot or mermaid
+//	POST /services/:id/transition     moves the service with {"event": ...}
+//	POST /services/:id/cancel         cancels the service build, compensating
+//	                                  it with {"compensate": true}
+//	POST /services/:id/resume         resumes a failed or interrupted build
+//
+// When ADMIN_TOKEN is set requests must carry it as a bearer token, and
+// changes are refused unless it is set. Changes on services owned by
+// another shard are refused with the owner shard, to be sent to it
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token != "" && !authorized(r, token) {
+		adminError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/services"), "/"), "/")
+	id := parts[0]
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if len(parts) > 2 {
+		adminError(w, http.StatusNotFound, errors.New("Not found"))
+		return
+	}
+
+	method := http.MethodGet
+	switch action {
+	case "transition", "cancel", "resume":
+		method = http.MethodPost
+	case "", "history", "graph":
+	default:
+		adminError(w, http.StatusNotFound, errors.New("Not found"))
+		return
+	}
+	if r.Method != method {
+		adminError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	if method == http.MethodPost {
+		if token == "" {
+			adminError(w, http.StatusForbidden, errors.New("Changes need ADMIN_TOKEN to be configured"))
+			return
+		}
+		if !sh.owns(id) {
+			adminMisdirected(w, id)
+			return
+		}
+	}
+
+	if id == "" {
+		adminListServices(w, r.URL.Query().Get("all") == "true")
+		return
+	}
+
+	switch action {
+	case "":
+		s := p.getService(id)
+		if s == nil {
+			adminError(w, http.StatusNotFound, ErrServiceNotFound)
+			return
+		}
+		wf, _ := NewWorkflow(&s)
+		adminReply(w, map[string]interface{}{"service": s, "workflow": wf})
+	case "history":
+		s := p.getService(id)
+		if s == nil {
+			adminError(w, http.StatusNotFound, ErrServiceNotFound)
+			return
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		history, err := serviceHistory(s, offset, limit)
+		if err != nil {
+			adminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		adminReply(w, history)
+	case "graph":
+		adminGraph(w, id, r.URL.Query().Get("format"))
+	case "transition":
+		var body struct {
+			Event string `json:"event"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Event == "" {
+			adminError(w, http.StatusBadRequest, errors.New("An event is required"))
+			return
+		}
+		received := map[string]interface{}{"id": id, "event": body.Event}
+		err := dispatchAdmin(id, adminTransitionSubject, received, func() error {
+			return processForcedTransition(id, body.Event)
+		})
+		adminResult(w, id, nil, err)
+	case "cancel":
+		var body struct {
+			Compensate bool `json:"compensate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			adminError(w, http.StatusBadRequest, errors.New("Invalid cancel request"))
+			return
+		}
+		received := map[string]interface{}{"id": id, "compensate": body.Compensate}
+		err := dispatchAdmin(id, cancelSubject, received, func() error {
+			return processServiceCancel(id, body.Compensate)
+		})
+		adminResult(w, id, nil, err)
+	case "resume":
+		var events []string
+		err := dispatchAdmin(id, resumeSubject, map[string]interface{}{"id": id}, func() error {
+			var err error
+			events, err = processServiceResume(id)
+			return err
+		})
+		adminResult(w, id, events, err)
+	}
+}
+
+// adminListServices : replies with the persisted services, only the ones
+// in flight unless all are requested
+func adminListServices(w http.ResponseWriter, all bool) {
+	keys, err := p.list()
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	services := []serviceSummary{}
+	for _, k := range keys {
+		s := p.getService(k)
+		if s == nil || (!all && !inProgress(&s)) {
+			continue
+		}
+		id, _ := s["id"].(string)
+		name, _ := s["name"].(string)
+		status, _ := s["status"].(string)
+		services = append(services, serviceSummary{ID: id, Name: name, Status: status})
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ID < services[j].ID
+	})
+
+	adminReply(w, services)
+}
+
+// adminGraph : replies with the workflow graph of a service on its
+// current state, as dot unless mermaid is requested
+func adminGraph(w http.ResponseWriter, id string, format string) {
+	s := p.getService(id)
+	if s == nil {
+		adminError(w, http.StatusNotFound, ErrServiceNotFound)
+		return
+	}
+	wf, err := NewWorkflow(&s)
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	switch format {
+	case "", "dot":
+		w.Write([]byte(wf.DOT(&s)))
+	case "mermaid":
+		w.Write([]byte(wf.Mermaid(&s)))
+	default:
+		adminError(w, http.StatusBadRequest, errors.New("Unknown graph format "+format))
+	}
+}
+
+// authorized : checks the request carries the given bearer token
+func authorized(r *http.Request, token string) bool {
+	given := []byte(r.Header.Get("Authorization"))
+	expected := []byte("Bearer " + token)
+
+	return subtle.ConstantTimeCompare(given, expected) == 1
+}
+
+// adminMisdirected : replies the service is owned by another shard
+func adminMisdirected(w http.ResponseWriter, id string) {
+	owner := sh.owner(id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMisdirectedRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": "Service is owned by shard " + strconv.Itoa(owner),
+		"shard": owner,
+	})
+}
+
+// dispatchAdmin : runs a change requested through the admin api on the
+// service mailbox, so it doesn't race with the messages being processed,
+// and waits for its result. The change is recorded as a message received
+// with the given subject and body
+func dispatchAdmin(id string, subject string, body map[string]interface{}, job func() error) error {
+	done := make(chan error, 1)
+
+	dp.dispatch(id, func() {
+		defer inbound.synthetic(id, subject, body)()
+		var err error
+		retryOnConflict("admin", func() error {
+			err = job()
+			return err
+		})
+		done <- err
+	})
+
+	return <-done
+}
+
+// processForcedTransition : moves a service with the given event as if it
+// was received, emitting the next events on its workflow
+func processForcedTransition(id string, event string) error {
+	service := p.getService(id)
+	if service == nil {
+		return ErrServiceNotFound
+	}
+	if !em.canMove(&service, event) {
+		return ErrInvalidTransition
+	}
+
+	logger.info("forced transition", serviceFields(&service).with("event", event))
+
+	return advance(&service, event, adminTransitionSubject)
+}
+
+// adminResult : replies with the result of a change on a service
+func adminResult(w http.ResponseWriter, id string, events []string, err error) {
+	switch err {
+	case nil:
+	case ErrServiceNotFound:
+		adminError(w, http.StatusNotFound, err)
+		return
+	case ErrInvalidTransition, ErrNotCancellable, ErrNotResumable, ErrRevisionConflict:
+		adminError(w, http.StatusConflict, err)
+		return
+	default:
+		adminError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	reply := map[string]interface{}{"id": id}
+	if s := p.getService(id); s != nil {
+		reply["status"] = s["status"]
+	}
+	if events != nil {
+		reply["events"] = events
+	}
+	adminReply(w, reply)
+}
+
+// adminReply : replies with the given value as json
+func adminReply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// adminError : replies with the given error and status code
+func adminError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,154 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func adminRequest(method string, path string, body string) (int, map[string]interface{}, string) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	newAdminMux().ServeHTTP(w, req)
+
+	var reply map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &reply)
+
+	return w.Code, reply, w.Body.String()
+}
+
+func TestAdminAPI(t *testing.T) {
+	Convey("Given a service waiting for its components", t, func() {
+		setup()
+		r := &recorder{}
+		defer sandbox(r)()
+
+		os.Setenv("ADMIN_TOKEN", "secret")
+		defer os.Unsetenv("ADMIN_TOKEN")
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "creating_components"
+		SaveService(s)
+
+		Convey("When the services in flight are listed", func() {
+			code, _, body := adminRequest("GET", "/services", "")
+
+			Convey("Then the service is listed with its status", func() {
+				So(code, ShouldEqual, 200)
+				So(body, ShouldContainSubstring, `"id":"test-generated-id"`)
+				So(body, ShouldContainSubstring, `"status":"creating_components"`)
+			})
+		})
+
+		Convey("When the service is requested", func() {
+			code, reply, _ := adminRequest("GET", "/services/test-generated-id", "")
+
+			Convey("Then its document and workflow are returned", func() {
+				So(code, ShouldEqual, 200)
+				So(reply["service"].(map[string]interface{})["status"], ShouldEqual, "creating_components")
+				So(len(reply["workflow"].(map[string]interface{})["arcs"].([]interface{})), ShouldBeGreaterThan, 0)
+			})
+		})
+
+		Convey("When an unknown service is requested", func() {
+			code, reply, _ := adminRequest("GET", "/services/unknown/history", "")
+
+			Convey("Then it is not found", func() {
+				So(code, ShouldEqual, 404)
+				So(reply["error"], ShouldEqual, ErrServiceNotFound.Error())
+			})
+		})
+
+		Convey("When a transition is forced", func() {
+			code, reply, _ := adminRequest("POST", "/services/test-generated-id/transition", `{"event":"components.create.done"}`)
+			_, history, _ := adminRequest("GET", "/services/test-generated-id/history", "")
+
+			Convey("Then the service is moved and recorded on its history", func() {
+				So(code, ShouldEqual, 200)
+				So(reply["status"], ShouldEqual, "updating_components")
+				entries := history["history"].([]interface{})
+				So(entries[len(entries)-1].(map[string]interface{})["subject"], ShouldEqual, adminTransitionSubject)
+				So(r.sent[len(r.sent)-1].Subject, ShouldEqual, "components.update")
+			})
+		})
+
+		Convey("When an invalid transition is forced", func() {
+			code, _, _ := adminRequest("POST", "/services/test-generated-id/transition", `{"event":"components.delete.done"}`)
+
+			Convey("Then it is rejected", func() {
+				So(code, ShouldEqual, 409)
+				So(p.getService("test-generated-id")["status"], ShouldEqual, "creating_components")
+			})
+		})
+
+		Convey("When the service is cancelled", func() {
+			code, reply, _ := adminRequest("POST", "/services/test-generated-id/cancel", "")
+
+			Convey("Then it is cancelled", func() {
+				So(code, ShouldEqual, 200)
+				So(reply["status"], ShouldEqual, cancelledStatus)
+				So(r.sent[len(r.sent)-1].Subject, ShouldEqual, cancelDoneSubject)
+			})
+		})
+
+		Convey("When the graph is requested as mermaid", func() {
+			_, _, body := adminRequest("GET", "/services/test-generated-id/graph?format=mermaid", "")
+
+			Convey("Then the workflow graph is returned", func() {
+				So(body, ShouldStartWith, "graph LR")
+			})
+		})
+
+		Convey("When a change is requested with GET", func() {
+			code, _, _ := adminRequest("GET", "/services/test-generated-id/cancel", "")
+
+			Convey("Then it is not allowed", func() {
+				So(code, ShouldEqual, 405)
+			})
+		})
+
+		Convey("When a token is required and not given", func() {
+			w := httptest.NewRecorder()
+			newAdminMux().ServeHTTP(w, httptest.NewRequest("GET", "/services", nil))
+
+			Convey("Then the request is unauthorized", func() {
+				So(w.Code, ShouldEqual, 401)
+			})
+		})
+
+		Convey("When a change is requested without a token configured", func() {
+			os.Unsetenv("ADMIN_TOKEN")
+			code, _, _ := adminRequest("POST", "/services/test-generated-id/cancel", "")
+
+			Convey("Then it is forbidden", func() {
+				So(code, ShouldEqual, 403)
+				So(p.getService("test-generated-id")["status"], ShouldEqual, "creating_components")
+			})
+		})
+
+		Convey("When a change is requested for a service owned by another shard", func() {
+			previous := sh
+			defer func() { sh = previous }()
+			sh = sharding{Shards: 2}
+			sh.Shard = 1 - sh.owner("test-generated-id")
+			code, reply, _ := adminRequest("POST", "/services/test-generated-id/cancel", "")
+
+			Convey("Then it is refused with the owner shard", func() {
+				So(code, ShouldEqual, 421)
+				So(reply["shard"], ShouldEqual, sh.owner("test-generated-id"))
+				So(p.getService("test-generated-id")["status"], ShouldEqual, "creating_components")
+			})
+		})
+	})
+}
@@ -0,0 +1,92 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("services")
+
+// BoltStore : persists services on an embedded boltdb file, so the
+// manager can run without a service-store
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore : opens (or creates) the boltdb file on the given path
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Get : gets the value stored for the given key
+func (s *BoltStore) Get(key string) (value string, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		value = string(tx.Bucket(boltBucket).Get([]byte(key)))
+		return nil
+	})
+
+	return value, err
+}
+
+// Set : stores a value for the given key
+func (s *BoltStore) Set(key string, value string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), []byte(value))
+	})
+}
+
+// Update : replaces the value for the given key with the one returned by
+// fn, inside a single boltdb transaction
+func (s *BoltStore) Update(key string, fn func(current string) (string, error)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		value, err := fn(string(b.Get([]byte(key))))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), []byte(value))
+	})
+}
+
+// Delete : removes the given key
+func (s *BoltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+// List : gets all stored keys
+func (s *BoltStore) List() (keys []string, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+
+	return keys, err
+}
+
+// Close : releases the underlying boltdb file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,20 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+// Broker : sends messages to the rest of the platform, nats when running
+// as a daemon, or a recorder when messages are replayed offline
+type Broker interface {
+	Publish(subject string, data []byte) error
+	Request(subject string, data []byte, timeout time.Duration) (*nats.Msg, error)
+}
+
+var bus Broker
@@ -0,0 +1,192 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats"
+	"github.com/tidwall/gjson"
+)
+
+// Subjects to cancel a service build and to notify it was cancelled
+const (
+	cancelSubject     = "service.cancel"
+	cancelDoneSubject = "service.cancel.done"
+)
+
+// Status of a service whose build was cancelled
+const cancelledStatus = "cancelled"
+
+// ErrNotCancellable : returned when cancelling a service which is not
+// being built
+var ErrNotCancellable = errors.New("Service is not in progress")
+
+// Queues a service cancellation on the service mailbox
+func manageServiceCancel(m *nats.Msg) {
+	mm := MessageManager{}
+	id, err := mm.getServiceID(m.Data)
+	if err != nil {
+		replyCancel(m, "", err)
+		return
+	}
+	compensate := gjson.GetBytes(m.Data, "compensate").Bool()
+
+	dp.dispatch(id, func() {
+		defer inbound.receive(id, m)()
+		var err error
+		retryOnConflict(m.Subject, func() error {
+			err = processServiceCancel(id, compensate)
+			if err == ErrRevisionConflict {
+				return err
+			}
+			return nil
+		})
+		replyCancel(m, id, err)
+	})
+}
+
+// processServiceCancel : marks a service as cancelling, so no more events
+// are emitted for it. It is cancelled once the batches in flight return,
+// after removing its created components if compensation is requested
+func processServiceCancel(id string, compensate bool) error {
+	service := p.getService(id)
+	if service == nil {
+		return ErrServiceNotFound
+	}
+	if cancelling(&service) {
+		return nil
+	}
+	status, _ := service["status"].(string)
+	if !inProgress(&service) || status == compensatingStatus {
+		return ErrNotCancellable
+	}
+
+	// A batch waiting to be sent again is not in flight anymore
+	if r, ok := service["retry"].(map[string]interface{}); ok {
+		pending, _ := service["pending_events"].([]interface{})
+		var remaining []interface{}
+		for _, e := range pending {
+			if e != r["event"] {
+				remaining = append(remaining, e)
+			}
+		}
+		service["pending_events"] = remaining
+		delete(service, "retry")
+	}
+
+	// Held events won't be emitted anymore
+	delete(service, "paused")
+	delete(service, "held_events")
+
+	logger.info("cancelling", serviceFields(&service).with("status_from", status))
+	service["cancel"] = map[string]interface{}{
+		"status":     "in_progress",
+		"from":       status,
+		"compensate": compensate,
+		"requested":  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	return advanceCancel(&service, cancelSubject, cancelSubject)
+}
+
+// advanceCancel : records the results received for a cancelling service
+// without emitting its next events. Once no batch is in flight it is
+// compensated if requested, and then cancelled
+func advanceCancel(service *map[string]interface{}, subject string, received string) error {
+	status, _ := (*service)["status"].(string)
+
+	if status == compensatingStatus {
+		event, finished := compensate(service, subject)
+		if !finished {
+			var events []string
+			if event != "" {
+				events = append(events, event)
+			}
+			return emit(service, events, false, received)
+		}
+		return finishCancel(service, received)
+	}
+
+	// Failed results already moved the service to pre-failed
+	if subject != "to_error" && subject != cancelSubject {
+		em.move(service, subject)
+	}
+	if awaitingResults(service, received) {
+		return emit(service, nil, false, received)
+	}
+
+	c, _ := (*service)["cancel"].(map[string]interface{})
+	if c["compensate"] == true && startCompensation(service) {
+		if event, finished := compensate(service, cancelSubject); !finished {
+			return emit(service, []string{event}, false, received)
+		}
+	}
+
+	return finishCancel(service, received)
+}
+
+// finishCancel : moves the service to cancelled and notifies it
+func finishCancel(service *map[string]interface{}, received string) error {
+	status, _ := (*service)["status"].(string)
+	recordTransition(service, status, cancelledStatus, cancelSubject, "")
+	(*service)["status"] = cancelledStatus
+	if c, ok := (*service)["cancel"].(map[string]interface{}); ok {
+		c["status"] = "completed"
+	}
+	for _, field := range []string{"timeout", "timed_out", "retry", "pending_events", "branches"} {
+		delete(*service, field)
+	}
+	logger.info("cancelled", serviceFields(service).with("status_from", status))
+
+	return emit(service, []string{cancelDoneSubject}, false, received)
+}
+
+// cancelling : checks if the service is being cancelled
+func cancelling(s *map[string]interface{}) bool {
+	c, _ := (*s)["cancel"].(map[string]interface{})
+	return c != nil && c["status"] == "in_progress"
+}
+
+// awaitingResults : checks if the service waits for results other than
+// the received one
+func awaitingResults(s *map[string]interface{}, received string) bool {
+	pending, _ := (*s)["pending_events"].([]interface{})
+	n := len(pending)
+
+	parts := strings.Split(received, ".")
+	event := strings.Join(parts[:len(parts)-1], ".")
+	if awaitsResult(event) {
+		for _, e := range pending {
+			if e == event {
+				n--
+				break
+			}
+		}
+	}
+
+	return n > 0
+}
+
+// Replies to a cancel request with the service status
+func replyCancel(m *nats.Msg, id string, err error) {
+	if m.Reply == "" {
+		return
+	}
+
+	reply := map[string]interface{}{"id": id}
+	if err != nil {
+		logger.error("can't cancel service", Fields{"service_id": id, "error": err})
+		reply["error"] = err.Error()
+	} else if s := p.getService(id); s != nil {
+		reply["status"] = s["status"]
+	}
+
+	body, _ := json.Marshal(reply)
+	bus.Publish(m.Reply, body)
+}
@@ -0,0 +1,148 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func sentSubjects(r *recorder) []string {
+	var subjects []string
+	for _, m := range r.sent {
+		if m.Kind == "publish" {
+			subjects = append(subjects, m.Subject)
+		}
+	}
+	return subjects
+}
+
+func TestCancel(t *testing.T) {
+	Convey("Given a service waiting for its components to be created", t, func() {
+		setup()
+		r := &recorder{}
+		defer sandbox(r)()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "creating_components"
+		(*s)["pending_events"] = []interface{}{"components.create"}
+		for _, a := range (*s)["workflow"].(map[string]interface{})["arcs"].([]interface{}) {
+			arc := a.(map[string]interface{})
+			if arc["event"] == "components.create" {
+				arc["compensation"] = "components.delete"
+			}
+		}
+		SaveService(s)
+
+		Convey("When it is cancelled", func() {
+			err := processServiceCancel("test-generated-id", false)
+			stored := p.getService("test-generated-id")
+
+			Convey("Then it waits for the batch in flight", func() {
+				So(err, ShouldBeNil)
+				So(stored["status"], ShouldEqual, "creating_components")
+				So(cancelling(&stored), ShouldBeTrue)
+				So(sentSubjects(r), ShouldBeEmpty)
+			})
+
+			Convey("And the batch is done", func() {
+				err := processInputMessage(&nats.Msg{
+					Subject: "components.create.done",
+					Data:    h.getFixture("./fixtures/components_create_done.json"),
+				})
+				stored := p.getService("test-generated-id")
+
+				Convey("Then no more events are emitted and the service is cancelled", func() {
+					So(err, ShouldBeNil)
+					So(stored["status"], ShouldEqual, cancelledStatus)
+					So(stored["cancel"].(map[string]interface{})["status"], ShouldEqual, "completed")
+					So(stored["pending_events"], ShouldBeNil)
+					So(sentSubjects(r), ShouldResemble, []string{cancelDoneSubject})
+				})
+			})
+
+			Convey("And it is cancelled again", func() {
+				err := processServiceCancel("test-generated-id", true)
+
+				Convey("Then the first request is kept", func() {
+					So(err, ShouldBeNil)
+					So(p.getService("test-generated-id")["cancel"].(map[string]interface{})["compensate"], ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("When it is cancelled with compensation", func() {
+			processServiceCancel("test-generated-id", true)
+			processInputMessage(&nats.Msg{
+				Subject: "components.create.done",
+				Data:    []byte(`{"service":"test-generated-id","status":"completed","components":[{"name":"added","field":"created","status":"completed"}]}`),
+			})
+			stored := p.getService("test-generated-id")
+
+			Convey("Then the created components are removed", func() {
+				So(stored["status"], ShouldEqual, compensatingStatus)
+				So(sentSubjects(r), ShouldResemble, []string{"components.delete"})
+			})
+
+			Convey("And the compensation is done", func() {
+				processInputMessage(&nats.Msg{
+					Subject: "components.delete.done",
+					Data:    []byte(`{"service":"test-generated-id","status":"completed","components":[{"name":"added","status":"completed"}]}`),
+				})
+				stored := p.getService("test-generated-id")
+
+				Convey("Then the service is cancelled", func() {
+					So(stored["status"], ShouldEqual, cancelledStatus)
+					So(stored["compensation"].(map[string]interface{})["status"], ShouldEqual, "completed")
+					So(sentSubjects(r)[len(sentSubjects(r))-1], ShouldEqual, cancelDoneSubject)
+				})
+			})
+		})
+
+		Convey("When a batch fails while cancelling", func() {
+			processServiceCancel("test-generated-id", false)
+			err := processInputMessage(&nats.Msg{
+				Subject: "components.create.error",
+				Data:    h.getFixture("./fixtures/components_create_error.json"),
+			})
+			stored := p.getService("test-generated-id")
+
+			Convey("Then the service is cancelled keeping the error", func() {
+				So(err, ShouldBeNil)
+				So(stored["status"], ShouldEqual, cancelledStatus)
+				So(stored["last_known_error"], ShouldEqual, "Rate limit exceeded")
+				So(sentSubjects(r), ShouldResemble, []string{cancelDoneSubject})
+			})
+		})
+	})
+
+	Convey("Given a finished service", t, func() {
+		setup()
+		defer withMemoryStore()()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "done"
+		SaveService(s)
+
+		Convey("When it is cancelled", func() {
+			err := processServiceCancel("test-generated-id", false)
+
+			Convey("Then it can't be cancelled", func() {
+				So(err, ShouldEqual, ErrNotCancellable)
+			})
+		})
+
+		Convey("When an unknown service is cancelled", func() {
+			err := processServiceCancel("unknown", false)
+
+			Convey("Then it is not found", func() {
+				So(err, ShouldEqual, ErrServiceNotFound)
+			})
+		})
+	})
+}
@@ -0,0 +1,229 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	ecc "github.com/ernestio/ernest-config-client"
+	"github.com/nats-io/nats"
+)
+
+// Usage for the command line subcommands
+const usage = `Usage: workflow-manager [command]
+
+Without command the workflow manager daemon is started.
+
+Commands:
+  graph [-format dot|mermaid] <service.json>   renders a service workflow
+  replay [-service service.json] <messages>    replays recorded messages offline
+  messages [-service service.json] <id>        prints the messages recorded for a service
+  ctl <command>                                operates a running manager, see ctl help
+`
+
+// runCommand : runs a command line subcommand returning its exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "graph":
+		return graphCommand(args[1:])
+	case "replay":
+		return replayCommand(args[1:])
+	case "messages":
+		return messagesCommand(args[1:])
+	case "ctl":
+		return ctlCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	}
+
+	fmt.Fprintln(os.Stderr, "Unknown command : "+args[0])
+	fmt.Fprint(os.Stderr, usage)
+
+	return 2
+}
+
+// graphCommand : renders the workflow of a service stored on a file,
+// highlighting its current status
+func graphCommand(args []string) int {
+	flags := flag.NewFlagSet("graph", flag.ContinueOnError)
+	format := flags.String("format", "dot", "graph format, dot or mermaid")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	s, err := readService(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	graph, err := renderGraph(&s, *format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Print(graph)
+
+	return 0
+}
+
+// replayCommand : replays the recorded messages stored on a file, one json
+// object with their subject and body each, printing every step
+func replayCommand(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	path := flags.String("service", "", "initial service definition")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	var initial map[string]interface{}
+	if *path != "" {
+		s, err := readService(*path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		initial = s
+	}
+
+	in := os.Stdin
+	if flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if err := replay(in, initial, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// messagesCommand : prints the inbound messages recorded for a service on
+// the configured storage backend, writing the service as it was before
+// them to the given file, to be replayed
+func messagesCommand(args []string) int {
+	flags := flag.NewFlagSet("messages", flag.ContinueOnError)
+	path := flags.String("service", "", "file to write the initial service to")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	var n *nats.Conn
+	backend := os.Getenv("STORAGE_BACKEND")
+	if backend == "" {
+		n = ecc.NewConfig(os.Getenv("NATS_URI")).Nats()
+		defer n.Close()
+	}
+	store, err := newStore(backend, n)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if c, ok := store.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	initial, err := writeMessages(store, flags.Arg(0), os.Stdout)
+	if err == nil && *path != "" {
+		err = ioutil.WriteFile(*path, []byte(initial), 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// writeMessages : writes the inbound messages recorded for a service as
+// events on the given store, one json object each, returning the service
+// as it was before them
+func writeMessages(store Store, id string, out io.Writer) (string, error) {
+	es, err := NewEventStore(store, 1)
+	if err != nil {
+		return "", err
+	}
+	messages, initial, err := es.Messages(id)
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return "", fmt.Errorf("No messages recorded for %s, are services persisted as events?", id)
+	}
+
+	enc := json.NewEncoder(out)
+	for _, m := range messages {
+		if err := enc.Encode(m); err != nil {
+			return "", err
+		}
+	}
+
+	return initial, nil
+}
+
+// renderGraph : renders the workflow of a service on the given format
+func renderGraph(s *map[string]interface{}, format string) (string, error) {
+	w, err := NewWorkflow(s)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case "dot":
+		return w.DOT(s), nil
+	case "mermaid":
+		return w.Mermaid(s), nil
+	}
+
+	return "", fmt.Errorf("Unsupported graph format : %s", format)
+}
+
+// readService : reads a service definition from a file, or from the
+// standard input if the path is -
+func readService(path string) (map[string]interface{}, error) {
+	var s map[string]interface{}
+	var body []byte
+	var err error
+
+	if path == "-" {
+		body, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		body, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(body, &s); err != nil {
+		return nil, fmt.Errorf("Invalid service definition %s : %s", path, err.Error())
+	}
+
+	return s, nil
+}
@@ -0,0 +1,103 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+)
+
+// Status of a service while the components created before its failure
+// are being removed
+const compensatingStatus = "compensating"
+
+// startCompensation : builds a batch for each compensation event defined
+// on the workflow with the components its arc batch created, in reverse
+// order so the last created components are the first removed. Returns
+// false if there is nothing to compensate, or the service was already
+// compensated
+func startCompensation(s *map[string]interface{}) bool {
+	if c, ok := (*s)["compensation"].(map[string]interface{}); ok {
+		if c["status"] == "in_progress" {
+			c["status"] = "errored"
+			c["failure"] = (*s)["last_known_error"]
+		}
+		return false
+	}
+
+	w, _ := NewWorkflow(s)
+	seen := make(map[string]bool)
+	var pending []interface{}
+
+	for i := len(w.Arcs) - 1; i >= 0; i-- {
+		a := w.Arcs[i]
+		if a.Compensation == "" || seen[a.Compensation] {
+			continue
+		}
+		batch, _ := (*s)[batchKey(a.Event)].(map[string]interface{})
+		completed, _ := batch["completed"].([]interface{})
+		if len(completed) == 0 {
+			continue
+		}
+		seen[a.Compensation] = true
+
+		(*s)[batchKey(a.Compensation)] = map[string]interface{}{
+			"status": "",
+			"items":  completed,
+		}
+		pending = append(pending, a.Compensation)
+	}
+
+	if len(pending) == 0 {
+		return false
+	}
+
+	id, _ := (*s)["id"].(string)
+	log.Println("[COMPENSATING]", id)
+	(*s)["compensation"] = map[string]interface{}{
+		"status":  "in_progress",
+		"from":    (*s)["status"],
+		"error":   (*s)["last_known_error"],
+		"current": "",
+		"pending": pending,
+	}
+	status, _ := (*s)["status"].(string)
+	recordTransition(s, status, compensatingStatus, "to_error", "")
+	(*s)["status"] = compensatingStatus
+
+	return true
+}
+
+// compensate : moves the compensation on when the batch being removed is
+// done, returning the next event to send. Once all batches are done the
+// service is back on pre-failed with its original error, and true is
+// returned
+func compensate(s *map[string]interface{}, subject string) (string, bool) {
+	c, _ := (*s)["compensation"].(map[string]interface{})
+	if c == nil {
+		return "", true
+	}
+
+	current, _ := c["current"].(string)
+	if current != "" && subject != current+".done" {
+		log.Println("Compensation is waiting for " + current + ".done, received " + subject)
+		return "", false
+	}
+
+	pending, _ := c["pending"].([]interface{})
+	if len(pending) == 0 {
+		c["status"] = "completed"
+		c["current"] = ""
+		(*s)["last_known_error"] = c["error"]
+		recordTransition(s, compensatingStatus, "pre-failed", subject, "")
+		(*s)["status"] = "pre-failed"
+		return "", true
+	}
+
+	event, _ := pending[0].(string)
+	c["current"] = event
+	c["pending"] = pending[1:]
+
+	return event, false
+}
@@ -0,0 +1,101 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+	"github.com/tidwall/gjson"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompensation(t *testing.T) {
+	Convey("Given a service with compensation which fails creating its components", t, func() {
+		setup()
+		defer withMemoryStore()()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "creating_components"
+		for _, a := range (*s)["workflow"].(map[string]interface{})["arcs"].([]interface{}) {
+			arc := a.(map[string]interface{})
+			if arc["event"] == "components.create" {
+				arc["compensation"] = "components.delete"
+			}
+		}
+		SaveService(s)
+
+		received := make(chan *nats.Msg, 1)
+		sub, _ := natsClient.Subscribe("components.delete", func(m *nats.Msg) {
+			received <- m
+		})
+		defer sub.Unsubscribe()
+
+		mm := MessageManager{}
+		service, subject, _ := mm.getServiceFromMessage("components.create.error", h.getFixture("./fixtures/components_create_error.json"))
+		So(subject, ShouldEqual, "to_error")
+
+		Convey("When the failure is processed", func() {
+			err := advance(&service, subject, "components.create.error")
+			stored := p.getService("test-generated-id")
+
+			Convey("Then the created components are removed", func() {
+				So(err, ShouldBeNil)
+				So(stored["status"], ShouldEqual, compensatingStatus)
+				So(stored["compensation"].(map[string]interface{})["current"], ShouldEqual, "components.delete")
+				select {
+				case m := <-received:
+					So(gjson.GetBytes(m.Data, "components.#").Int(), ShouldEqual, 1)
+					So(gjson.GetBytes(m.Data, "components.0.name").String(), ShouldEqual, "added")
+				case <-time.After(time.Second):
+					So("compensation was not sent", ShouldBeEmpty)
+				}
+			})
+
+			Convey("And the compensation is done", func() {
+				body := []byte(`{"service":"test-generated-id","status":"completed","components":[{"name":"added","status":"completed"}]}`)
+				service, subject, err := mm.getServiceFromMessage("components.delete.done", body)
+				So(err, ShouldBeNil)
+				err = advance(&service, subject, "components.delete.done")
+				stored := p.getService("test-generated-id")
+
+				Convey("Then the service is failed with its original error", func() {
+					So(err, ShouldBeNil)
+					So(stored["status"], ShouldEqual, "errored")
+					So(stored["last_known_error"], ShouldEqual, "Rate limit exceeded")
+					So(stored["compensation"].(map[string]interface{})["status"], ShouldEqual, "completed")
+				})
+			})
+
+			Convey("And the compensation fails", func() {
+				body := []byte(`{"service":"test-generated-id","status":"errored","components":[{"name":"added","status":"errored","error":"In use"}]}`)
+				service, subject, _ := mm.getServiceFromMessage("components.delete.error", body)
+				err := advance(&service, subject, "components.delete.error")
+				stored := p.getService("test-generated-id")
+
+				Convey("Then the service is failed", func() {
+					So(err, ShouldBeNil)
+					So(stored["status"], ShouldEqual, "errored")
+					c := stored["compensation"].(map[string]interface{})
+					So(c["status"], ShouldEqual, "errored")
+					So(c["error"], ShouldEqual, "Rate limit exceeded")
+					So(c["failure"], ShouldEqual, "In use")
+				})
+			})
+		})
+	})
+
+	Convey("Given a service without compensation which fails", t, func() {
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "pre-failed"
+
+		Convey("Then there is nothing to compensate", func() {
+			So(startCompensation(s), ShouldBeFalse)
+			So((*s)["status"], ShouldEqual, "pre-failed")
+		})
+	})
+}
@@ -0,0 +1,133 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Comparison operators supported on conditions, two chars operators go
+// first so >= is not read as >
+var conditionOperators = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+// evalCondition : evaluates a condition against a service document.
+//
+// A condition is a gjson path optionally compared with a value, as in
+// `components_to_create.items.#>0` or `type=="aws"`. Without comparison
+// the condition holds when the path exists and is not false, 0 or empty,
+// and can be negated with a leading `!`
+func evalCondition(data string, condition string) (bool, error) {
+	condition = strings.TrimSpace(condition)
+	if condition == "" {
+		return true, nil
+	}
+
+	path, op, value := splitCondition(condition)
+	if op == "" {
+		if strings.HasPrefix(path, "!") {
+			return !truthy(gjson.Get(data, strings.TrimSpace(path[1:]))), nil
+		}
+		return truthy(gjson.Get(data, path)), nil
+	}
+	if path == "" || value == "" {
+		return false, errors.New("Invalid condition : " + condition)
+	}
+
+	return compare(gjson.Get(data, path), op, value)
+}
+
+// splitCondition : splits a condition on its first operator outside of
+// gjson queries, strings or brackets
+func splitCondition(condition string) (string, string, string) {
+	depth := 0
+	quoted := false
+
+	for i := 0; i < len(condition); i++ {
+		switch c := condition[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case depth == 0:
+			for _, op := range conditionOperators {
+				if strings.HasPrefix(condition[i:], op) {
+					return strings.TrimSpace(condition[:i]), op, strings.TrimSpace(condition[i+len(op):])
+				}
+			}
+		}
+	}
+
+	return condition, "", ""
+}
+
+// truthy : checks if a gjson result should be considered as true
+func truthy(r gjson.Result) bool {
+	switch r.Type {
+	case gjson.True:
+		return true
+	case gjson.Number:
+		return r.Num != 0
+	case gjson.String:
+		return r.Str != ""
+	case gjson.JSON:
+		if r.IsArray() {
+			return len(r.Array()) > 0
+		}
+		return len(r.Map()) > 0
+	}
+	return false
+}
+
+// compare : compares a gjson result with a literal value, which can be
+// a number, a quoted string, true, false or null
+func compare(r gjson.Result, op string, value string) (bool, error) {
+	var cmp int
+
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		switch f := r.Float(); {
+		case f < n:
+			cmp = -1
+		case f > n:
+			cmp = 1
+		}
+	} else if value == "true" || value == "false" || value == "null" {
+		raw := r.Raw
+		if !r.Exists() {
+			raw = "null"
+		}
+		cmp = strings.Compare(raw, value)
+	} else {
+		if s, err := strconv.Unquote(value); err == nil {
+			value = s
+		}
+		cmp = strings.Compare(r.String(), value)
+	}
+
+	switch op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	}
+
+	return false, errors.New("Unsupported operator : " + op)
+}
@@ -0,0 +1,101 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConditions(t *testing.T) {
+	Convey("Given I have a service document", t, func() {
+		data := `{"type":"aws","bootstrapping":false,"networks_to_create":{"items":[]},"instances_to_create":{"items":[{"name":"web-1"},{"name":"web-2"}]}}`
+
+		Convey("When I evaluate conditions", func() {
+			cases := map[string]bool{
+				"":                                 true,
+				"instances_to_create.items.#>0":    true,
+				"instances_to_create.items.# == 2": true,
+				"instances_to_create.items.#<=1":   false,
+				"networks_to_create.items.#>0":     false,
+				"networks_to_create.items.#==0":    true,
+				`type=="aws"`:                      true,
+				`type!="aws"`:                      false,
+				"bootstrapping==false":             true,
+				"instances_to_create.items":        true,
+				"networks_to_create.items":         false,
+				"!networks_to_create.items":        true,
+				"unexisting":                       false,
+				"unexisting==null":                 true,
+				`instances_to_create.items.#(name=="web-2").name=="web-2"`: true,
+			}
+
+			Convey("Then they are evaluated against the service", func() {
+				for condition, expected := range cases {
+					ok, err := evalCondition(data, condition)
+					So(err, ShouldBeNil)
+					So(ok, ShouldEqual, expected)
+				}
+			})
+		})
+
+		Convey("When I evaluate an invalid condition", func() {
+			ok, err := evalCondition(data, "type==")
+
+			Convey("Then it does not hold", func() {
+				So(ok, ShouldBeFalse)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestConditionalArcs(t *testing.T) {
+	Convey("Given a service with conditional arcs and no networks to create", t, func() {
+		s, _ := h.getService("./fixtures/service_conditions.json")
+
+		Convey("When I get the next event", func() {
+			event := em.next(s)
+
+			Convey("Then the networks batch is skipped", func() {
+				So(event, ShouldEqual, "instances.create")
+			})
+		})
+
+		Convey("When the service moves on the next event", func() {
+			err := em.move(s, "instances.create")
+
+			Convey("Then it follows the arc whose condition holds", func() {
+				So(err, ShouldBeNil)
+				So((*s)["status"], ShouldEqual, "creating_instances")
+			})
+		})
+	})
+
+	Convey("Given a service with conditional arcs and networks to create", t, func() {
+		s, _ := h.getService("./fixtures/service_conditions.json")
+		(*s)["networks_to_create"].(map[string]interface{})["items"] = []interface{}{
+			map[string]interface{}{"name": "net-1"},
+		}
+
+		Convey("When I get the next event", func() {
+			event := em.next(s)
+
+			Convey("Then the networks batch is created", func() {
+				So(event, ShouldEqual, "networks.create")
+			})
+		})
+
+		Convey("When the service receives an event whose condition does not hold", func() {
+			err := em.move(s, "instances.create")
+
+			Convey("Then the transition is not valid", func() {
+				So(err, ShouldNotBeNil)
+				So((*s)["status"], ShouldEqual, "started")
+			})
+		})
+	})
+}
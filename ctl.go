@@ -0,0 +1,306 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Usage for the ctl subcommands
+const ctlUsage = `Usage: workflow-manager ctl [-addr url] [-token token] <command>
+
+Commands talking to a running manager through its admin api:
+  status <id>                                  shows a service status
+  graph [-format dot|mermaid] <id>             renders a service workflow
+  cancel [-compensate] <id>                    cancels a service build
+  resume <id>                                  resumes a failed service
+
+Offline commands:
+  validate <service.json>                      validates a service workflow
+  simulate [-fail event,...] <service.json>    runs a service workflow
+`
+
+// ctlClient : sends requests to the admin api of a manager
+type ctlClient struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// ctlCommand : runs a ctl subcommand returning its exit code
+func ctlCommand(args []string) int {
+	return runCtl(args, os.Stdout, os.Stderr)
+}
+
+// runCtl : runs a ctl subcommand writing its output to out and its errors
+// to errs. The manager address defaults to WORKFLOW_MANAGER_ADDR, or to
+// the local one
+func runCtl(args []string, out io.Writer, errs io.Writer) int {
+	addr := os.Getenv("WORKFLOW_MANAGER_ADDR")
+	if addr == "" {
+		addr = "http://localhost:8081"
+	}
+
+	flags := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	flags.SetOutput(errs)
+	flags.StringVar(&addr, "addr", addr, "admin api address")
+	token := flags.String("token", os.Getenv("ADMIN_TOKEN"), "admin api token")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 {
+		fmt.Fprint(errs, ctlUsage)
+		return 2
+	}
+
+	c := &ctlClient{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  *token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	command, args := flags.Arg(0), flags.Args()[1:]
+
+	var err error
+	switch command {
+	case "status", "resume":
+		if len(args) != 1 {
+			fmt.Fprint(errs, ctlUsage)
+			return 2
+		}
+		if command == "status" {
+			err = c.status(args[0], out)
+		} else {
+			err = c.change(args[0], command, nil, out)
+		}
+	case "cancel":
+		sub := flag.NewFlagSet("cancel", flag.ContinueOnError)
+		sub.SetOutput(errs)
+		compensate := sub.Bool("compensate", false, "removes the created components")
+		if sub.Parse(args) != nil || sub.NArg() != 1 {
+			fmt.Fprint(errs, ctlUsage)
+			return 2
+		}
+		err = c.change(sub.Arg(0), command, map[string]interface{}{"compensate": *compensate}, out)
+	case "graph":
+		sub := flag.NewFlagSet("graph", flag.ContinueOnError)
+		sub.SetOutput(errs)
+		format := sub.String("format", "dot", "graph format, dot or mermaid")
+		if sub.Parse(args) != nil || sub.NArg() != 1 {
+			fmt.Fprint(errs, ctlUsage)
+			return 2
+		}
+		err = c.graph(sub.Arg(0), *format, out)
+	case "validate":
+		if len(args) != 1 {
+			fmt.Fprint(errs, ctlUsage)
+			return 2
+		}
+		err = validateDefinition(args[0], out)
+	case "simulate":
+		sub := flag.NewFlagSet("simulate", flag.ContinueOnError)
+		sub.SetOutput(errs)
+		fail := sub.String("fail", "", "comma separated events answered as errored")
+		if sub.Parse(args) != nil || sub.NArg() != 1 {
+			fmt.Fprint(errs, ctlUsage)
+			return 2
+		}
+		err = simulateDefinition(sub.Arg(0), *fail, out)
+	case "help":
+		fmt.Fprint(out, ctlUsage)
+	default:
+		fmt.Fprintln(errs, "Unknown ctl command : "+command)
+		fmt.Fprint(errs, ctlUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(errs, err)
+		return 1
+	}
+
+	return 0
+}
+
+// status : prints the status of a service, with its branches and last
+// error if any
+func (c *ctlClient) status(id string, out io.Writer) error {
+	var reply struct {
+		Service map[string]interface{} `json:"service"`
+	}
+	if err := c.do("GET", "/services/"+url.PathEscape(id), nil, &reply); err != nil {
+		return err
+	}
+
+	s := reply.Service
+	fmt.Fprintf(out, "id: %v\nname: %v\nstatus: %v\n", s["id"], s["name"], s["status"])
+	if branches, ok := s["branches"].(map[string]interface{}); ok {
+		var names []string
+		for b := range branches {
+			names = append(names, b)
+		}
+		sort.Strings(names)
+		for _, b := range names {
+			fmt.Fprintf(out, "branch %s: %v\n", b, branches[b])
+		}
+	}
+	if e, ok := s["last_known_error"].(string); ok && e != "" {
+		fmt.Fprintf(out, "error: %s\n", e)
+	}
+
+	return nil
+}
+
+// change : requests a change on a service, printing its resulting status
+func (c *ctlClient) change(id string, action string, body interface{}, out io.Writer) error {
+	var reply struct {
+		ID     string   `json:"id"`
+		Status string   `json:"status"`
+		Events []string `json:"events"`
+	}
+	if err := c.do("POST", "/services/"+url.PathEscape(id)+"/"+action, body, &reply); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%s: %s\n", reply.ID, reply.Status)
+	for _, e := range reply.Events {
+		fmt.Fprintf(out, "sent %s\n", e)
+	}
+
+	return nil
+}
+
+// graph : prints the workflow graph of a service
+func (c *ctlClient) graph(id string, format string, out io.Writer) error {
+	body, err := c.request("GET", "/services/"+url.PathEscape(id)+"/graph?format="+url.QueryEscape(format), nil)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(body)
+
+	return err
+}
+
+// do : sends a request to the admin api, with the given body as json if
+// any, decoding its json reply
+func (c *ctlClient) do(method string, path string, body interface{}, reply interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	data, err := c.request(method, path, data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, reply)
+}
+
+// request : sends a request to the admin api, failing with the error
+// replied if it is not successful
+func (c *ctlClient) request(method string, path string, data []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, c.addr+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, errors.New(e.Error)
+		}
+		return nil, errors.New("Request failed : " + resp.Status)
+	}
+
+	return body, nil
+}
+
+// validateDefinition : validates the workflow of a service stored on a
+// file, printing every issue found
+func validateDefinition(path string, out io.Writer) error {
+	s, err := readService(path)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateWorkflow(&s)
+	if verr, ok := err.(*ValidationError); ok {
+		for _, issue := range verr.Issues {
+			fmt.Fprintf(out, "%s: %s\n", issue.Code, issue.Message)
+		}
+		return errors.New("Invalid workflow")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Valid workflow")
+
+	return nil
+}
+
+// simulateDefinition : runs the workflow of a service stored on a file,
+// answering the given comma separated events as errored
+func simulateDefinition(path string, fail string, out io.Writer) error {
+	s, err := readService(path)
+	if err != nil {
+		return err
+	}
+	if err := ValidateWorkflow(&s); err != nil {
+		return err
+	}
+
+	var failing []string
+	if fail != "" {
+		failing = strings.Split(fail, ",")
+	}
+
+	// Simulated messages are only printed as steps
+	std, level := log.Writer(), logger.level
+	log.SetOutput(ioutil.Discard)
+	logger.level = levelError + 1
+	defer func() {
+		log.SetOutput(std)
+		logger.level = level
+	}()
+
+	final, err := simulate(s, failing, out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "final status: %v\n", final["status"])
+
+	return nil
+}
@@ -0,0 +1,114 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCtl(t *testing.T) {
+	Convey("Given a manager serving its admin api", t, func() {
+		setup()
+		defer sandbox(&recorder{})()
+
+		os.Setenv("ADMIN_TOKEN", "secret")
+		defer os.Unsetenv("ADMIN_TOKEN")
+		server := httptest.NewServer(newAdminMux())
+		defer server.Close()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "creating_components"
+		SaveService(s)
+
+		var out, errs bytes.Buffer
+		ctl := func(args ...string) int {
+			return runCtl(append([]string{"-addr", server.URL}, args...), &out, &errs)
+		}
+
+		Convey("When the status of a service is requested", func() {
+			code := ctl("status", "test-generated-id")
+
+			Convey("Then it is printed", func() {
+				So(code, ShouldEqual, 0)
+				So(out.String(), ShouldContainSubstring, "status: creating_components")
+			})
+		})
+
+		Convey("When the status of an unknown service is requested", func() {
+			code := ctl("status", "unknown")
+
+			Convey("Then the api error is printed", func() {
+				So(code, ShouldEqual, 1)
+				So(errs.String(), ShouldContainSubstring, ErrServiceNotFound.Error())
+			})
+		})
+
+		Convey("When a service is cancelled", func() {
+			code := ctl("cancel", "test-generated-id")
+
+			Convey("Then its resulting status is printed", func() {
+				So(code, ShouldEqual, 0)
+				So(out.String(), ShouldStartWith, "test-generated-id: ")
+				So(out.String(), ShouldNotContainSubstring, "creating_components")
+			})
+		})
+
+		Convey("When the graph of a service is requested", func() {
+			code := ctl("graph", "-format", "mermaid", "test-generated-id")
+
+			Convey("Then it is printed", func() {
+				So(code, ShouldEqual, 0)
+				So(out.String(), ShouldStartWith, "graph LR")
+			})
+		})
+
+		Convey("When an unknown command is run", func() {
+			code := ctl("unknown")
+
+			Convey("Then the usage is printed", func() {
+				So(code, ShouldEqual, 2)
+				So(errs.String(), ShouldContainSubstring, "Usage")
+			})
+		})
+	})
+
+	Convey("Given a service definition", t, func() {
+		var out, errs bytes.Buffer
+
+		Convey("When it is validated", func() {
+			code := runCtl([]string{"validate", "./fixtures/service_components.json"}, &out, &errs)
+
+			Convey("Then it is valid", func() {
+				So(code, ShouldEqual, 0)
+				So(out.String(), ShouldContainSubstring, "Valid workflow")
+			})
+		})
+
+		Convey("When it is simulated", func() {
+			code := runCtl([]string{"simulate", "./fixtures/service_components.json"}, &out, &errs)
+
+			Convey("Then every step is printed until the service is done", func() {
+				So(code, ShouldEqual, 0)
+				So(out.String(), ShouldContainSubstring, `"received":"components.create.done"`)
+				So(out.String(), ShouldContainSubstring, "final status: done")
+			})
+		})
+
+		Convey("When it is simulated with a failing event", func() {
+			code := runCtl([]string{"simulate", "-fail", "components.update", "./fixtures/service_components.json"}, &out, &errs)
+
+			Convey("Then the service ends errored", func() {
+				So(code, ShouldEqual, 0)
+				So(out.String(), ShouldContainSubstring, `"received":"components.update.error"`)
+				So(out.String(), ShouldNotContainSubstring, "final status: done")
+			})
+		})
+	})
+}
@@ -0,0 +1,74 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// dispatcher : keeps a mailbox per service id, so jobs for the same
+// service are run strictly in the order they were received while jobs
+// for different services run in parallel
+type dispatcher struct {
+	mu        sync.Mutex
+	mailboxes map[string][]func()
+}
+
+// newDispatcher : dispatcher constructor
+func newDispatcher() *dispatcher {
+	return &dispatcher{mailboxes: make(map[string][]func())}
+}
+
+// dispatch : queues a job on the mailbox for the given key, starting a
+// worker for it if there is none running
+func (d *dispatcher) dispatch(key string, job func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if queue, ok := d.mailboxes[key]; ok {
+		d.mailboxes[key] = append(queue, job)
+		return
+	}
+	d.mailboxes[key] = []func(){job}
+
+	go d.run(key)
+}
+
+// run : processes all queued jobs for a key, the mailbox is removed once
+// it gets empty
+func (d *dispatcher) run(key string) {
+	for {
+		d.mu.Lock()
+		queue := d.mailboxes[key]
+		if len(queue) == 0 {
+			delete(d.mailboxes, key)
+			d.mu.Unlock()
+			return
+		}
+		job := queue[0]
+		d.mailboxes[key] = queue[1:]
+		d.mu.Unlock()
+
+		job()
+	}
+}
+
+// wait : waits until all queued jobs are done or the timeout is reached,
+// returning how many services still have jobs queued
+func (d *dispatcher) wait(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		d.mu.Lock()
+		pending := len(d.mailboxes)
+		d.mu.Unlock()
+
+		if pending == 0 || time.Now().After(deadline) {
+			return pending
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
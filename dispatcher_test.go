@@ -0,0 +1,72 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDispatcherOrdering(t *testing.T) {
+	Convey("Given I have a dispatcher", t, func() {
+		d := newDispatcher()
+
+		Convey("When I dispatch several jobs for the same service", func() {
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			var processed []int
+
+			for i := 0; i < 50; i++ {
+				n := i
+				wg.Add(1)
+				d.dispatch("service-1", func() {
+					mu.Lock()
+					processed = append(processed, n)
+					mu.Unlock()
+					wg.Done()
+				})
+			}
+			wg.Wait()
+
+			Convey("Then they are processed in order", func() {
+				So(len(processed), ShouldEqual, 50)
+				for i, n := range processed {
+					So(n, ShouldEqual, i)
+				}
+			})
+		})
+
+		Convey("When a job for a service is blocked", func() {
+			release := make(chan bool)
+			done := make(chan bool)
+
+			d.dispatch("service-1", func() { <-release })
+			d.dispatch("service-2", func() { done <- true })
+
+			Convey("Then jobs for other services are still processed", func() {
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					So("service-2 job was not processed", ShouldBeEmpty)
+				}
+				close(release)
+			})
+		})
+
+		Convey("When I wait for its jobs to finish", func() {
+			release := make(chan bool)
+			d.dispatch("service-1", func() { <-release })
+
+			Convey("Then it times out while they are running", func() {
+				So(d.wait(20*time.Millisecond), ShouldEqual, 1)
+				close(release)
+				So(d.wait(time.Second), ShouldEqual, 0)
+			})
+		})
+	})
+}
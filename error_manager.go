@@ -5,7 +5,9 @@
 package main
 
 import (
+	"log"
 	"strings"
+	"time"
 )
 
 // ErrorSubjects : Subjects to be exported
@@ -41,9 +43,99 @@ func (em *ErrorManager) markAsFailed(s *map[string]interface{}, subject string,
 	}
 
 	(*s)["last_known_error"] = em.getErrorMessage(input)
+	countFailure(em.getErrorCodes(input)...)
+	status, _ := (*s)["status"].(string)
+	recordTransition(s, status, "pre-failed", subject, "")
 	(*s)["status"] = "pre-failed"
 }
 
+// markForRetry : if the failed batch has a retry policy allowing it,
+// records the successful components and prepares the batch to be sent
+// again with only the failed ones
+func (em *ErrorManager) markForRetry(s *map[string]interface{}, subject string, body []byte) bool {
+	parts := strings.Split(subject, ".")
+	if len(parts) != 3 || parts[0] == "service" || cancelling(s) {
+		return false
+	}
+	event := parts[0] + "." + parts[1]
+
+	batch, ok := (*s)[batchKey(event)].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	policy := batchRetryPolicy(s, event)
+	if policy == nil {
+		return false
+	}
+
+	input := NewGenericComponentMsg(body)
+	attempt := 1
+	if n, ok := batch["attempts"].(float64); ok {
+		attempt = int(n)
+	}
+	if attempt >= policy.MaxAttempts || !policy.allows(em.getErrorCodes(input)) {
+		return false
+	}
+	backoff, err := policy.backoff(attempt + 1)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	(*s)["last_known_error"] = em.getErrorMessage(input)
+
+	switch parts[1] {
+	case "create":
+		TransferCreated(s, parts[0], input)
+	case "update":
+		TransferUpdated(s, parts[0], input)
+	case "delete":
+		TransferDeleted(s, parts[0], input)
+	default:
+		return false
+	}
+
+	// Failed components are sent again without their previous result
+	items, _ := batch["items"].([]interface{})
+	for i, item := range items {
+		if c, ok := item.(map[string]interface{}); ok {
+			component := make(map[string]interface{})
+			for k, v := range c {
+				if k != "status" && k != "error" {
+					component[k] = v
+				}
+			}
+			items[i] = component
+		}
+	}
+
+	status, _ := (*s)["status"].(string)
+	batch["attempts"] = attempt + 1
+	(*s)["retry"] = map[string]interface{}{
+		"status":   status,
+		"event":    event,
+		"attempt":  attempt + 1,
+		"deadline": time.Now().Add(backoff).UTC().Format(time.RFC3339Nano),
+	}
+
+	return true
+}
+
+// getErrorCodes : gets the error codes of a failed message and its
+// errored components
+func (em *ErrorManager) getErrorCodes(input GenericComponentMsg) (codes []string) {
+	if input.ErrorCode != "" {
+		codes = append(codes, input.ErrorCode)
+	}
+	for _, c := range input.Components {
+		inHash, _ := c.(map[string]interface{})
+		if code, ok := inHash["error_code"].(string); ok && code != "" {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
 func (em *ErrorManager) getErrorMessage(input GenericComponentMsg) string {
 	for _, c := range input.Components {
 		inHash := c.(map[string]interface{})
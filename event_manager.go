@@ -5,8 +5,11 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"log"
+	"strings"
+	"time"
 )
 
 // eventManager : manages service moving through events
@@ -15,27 +18,71 @@ type eventManager struct {
 
 // manage : Manage a trigger based on a given definition
 func (em *eventManager) manage(subject string, s *map[string]interface{}) (string, error) {
+	events, err := em.manageEvents(subject, s)
+	if len(events) == 0 {
+		return "", err
+	}
+
+	return events[0], err
+}
+
+// manageEvents : Manage a trigger based on a given definition returning
+// all the events to be sent, as a fork sends an event per branch
+func (em *eventManager) manageEvents(subject string, s *map[string]interface{}) ([]string, error) {
 	err := em.move(s, subject)
 	if err != nil {
 		log.Println(err)
-		return "", err
+		return nil, err
 	}
-	event := em.next(s)
 
-	return event, err
+	return em.nextEvents(s), nil
 }
 
 // next : Prepares a proper message and sends the next event
 func (em *eventManager) next(s *map[string]interface{}) string {
+	events := em.nextEvents(s)
+	if len(events) == 0 {
+		return ""
+	}
+
+	return events[0]
+}
+
+// nextEvents : Gets the next event for the service status, or the next
+// event of each branch when the service is on a fork. Branches waiting for
+// the result of the event they sent have nothing to send
+func (em *eventManager) nextEvents(s *map[string]interface{}) (events []string) {
+	if cancelling(s) {
+		return nil
+	}
 	w, _ := NewWorkflow(s)
 	status, _ := (*s)["status"].(string)
-	event, err := w.nextEvent(status)
+	data := serviceData(s)
+
+	if branches := w.branches(status); len(branches) > 0 {
+		statuses, _ := (*s)["branches"].(map[string]interface{})
+		for _, b := range branches {
+			bs, _ := statuses[b].(string)
+			if event, err := w.nextBranchEvent(b, bs, data); err == nil && !resultEvent(event) {
+				events = append(events, event)
+			}
+		}
+		return events
+	}
+
+	event, err := w.nextEvent(status, data)
 	if err != nil {
 		log.Println(err)
-		return ""
+		return nil
 	}
 
-	return event
+	return []string{event}
+}
+
+// resultEvent : checks if the given event is the result of a component
+// action, which is received instead of sent
+func resultEvent(event string) bool {
+	return strings.HasSuffix(event, ".done") || strings.HasSuffix(event, ".error")
 }
 
 // move : Moves a service to its next status and return a
@@ -49,14 +96,143 @@ func (em *eventManager) move(s *map[string]interface{}, event string) error {
 	(*s)["status"] = status
 
 	w, _ := NewWorkflow(s)
-	a, err := w.nextArc(status, event)
+	data := serviceData(s)
+	a, err := w.nextArc(status, event, data)
 	if err != nil {
-		return errors.New("Invalid status(" + status + ") event (" + event + ") pair")
+		if err := em.moveBranch(s, &w, event, data); err != nil {
+			return errors.New("Invalid status(" + status + ") event (" + event + ") pair")
+		}
+		return nil
 	}
 
 	// Update status
+	recordTransition(s, status, a.To, event, "")
 	(*s)["status"] = a.To
+	em.setTimeout(s, a)
+	em.fork(s, &w)
 
 	// Return new status
 	return nil
 }
+
+// canMove : Checks the service or any of its branches can be moved with
+// the given event
+func (em *eventManager) canMove(s *map[string]interface{}, event string) bool {
+	status, _ := (*s)["status"].(string)
+	if status == "" {
+		status = "created"
+	}
+
+	w, _ := NewWorkflow(s)
+	data := serviceData(s)
+	if _, err := w.nextArc(status, event, data); err == nil {
+		return true
+	}
+
+	statuses, _ := (*s)["branches"].(map[string]interface{})
+	for _, b := range w.branches(status) {
+		bs, _ := statuses[b].(string)
+		if _, err := w.nextBranchArc(b, bs, event, data); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// moveBranch : Moves the branch of a forked service receiving the event,
+// joining all branches when they are done
+func (em *eventManager) moveBranch(s *map[string]interface{}, w *Workflow, event string, data string) error {
+	status, _ := (*s)["status"].(string)
+	statuses, ok := (*s)["branches"].(map[string]interface{})
+	if !ok {
+		return errors.New("Service is not on a fork")
+	}
+	branches := w.branches(status)
+
+	for _, b := range branches {
+		bs, _ := statuses[b].(string)
+		a, err := w.nextBranchArc(b, bs, event, data)
+		if err != nil {
+			continue
+		}
+		recordTransition(s, bs, a.To, event, b)
+		statuses[b] = a.To
+		em.join(s, w, branches, event)
+		return nil
+	}
+
+	return errors.New("No branch is expecting " + event)
+}
+
+// fork : Starts all branches when the service reaches a fork
+func (em *eventManager) fork(s *map[string]interface{}, w *Workflow) {
+	status, _ := (*s)["status"].(string)
+	branches := w.branches(status)
+	if len(branches) == 0 {
+		return
+	}
+
+	statuses := make(map[string]interface{})
+	for _, b := range branches {
+		statuses[b] = status
+	}
+	(*s)["branches"] = statuses
+}
+
+// join : Moves the service to the join status once all branches are done
+func (em *eventManager) join(s *map[string]interface{}, w *Workflow, branches []string, event string) {
+	statuses, _ := (*s)["branches"].(map[string]interface{})
+
+	var join string
+	for _, b := range branches {
+		bs, _ := statuses[b].(string)
+		if !w.branchDone(b, bs) {
+			return
+		}
+		if join != "" && join != bs {
+			log.Println("Branches can't be joined, they finished on " + join + " and " + bs)
+			return
+		}
+		join = bs
+	}
+
+	delete(*s, "branches")
+	delete(*s, "timeout")
+	status, _ := (*s)["status"].(string)
+	recordTransition(s, status, join, event, "")
+	(*s)["status"] = join
+	em.fork(s, w)
+}
+
+// setTimeout : Sets the deadline for the service to leave the status it
+// reached through the given arc, if the arc defines a timeout
+func (em *eventManager) setTimeout(s *map[string]interface{}, a *Arc) {
+	delete(*s, "timeout")
+	if a.Timeout == "" {
+		return
+	}
+
+	d, err := time.ParseDuration(a.Timeout)
+	if err != nil {
+		log.Println("Invalid timeout " + a.Timeout + " on " + a.Event)
+		return
+	}
+
+	(*s)["timeout"] = map[string]interface{}{
+		"status":   a.To,
+		"event":    a.Event,
+		"deadline": time.Now().Add(d).UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// serviceData : gets the json representation of a service, where arc
+// conditions are evaluated
+func serviceData(s *map[string]interface{}) string {
+	data, err := json.Marshal(withoutBookkeeping(s))
+	if err != nil {
+		log.Println(err)
+	}
+
+	return string(data)
+}
@@ -0,0 +1,491 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+// Separates a service id from the sequence of its events on the
+// underlying store keys
+const eventKeySeparator = "#"
+
+// EventStore : persists every change of a value as an event holding the
+// inbound message which caused it, the transitions it recorded and what
+// changed, on top of another store. Values are rebuilt replaying the
+// changes from the last snapshot, which is taken every given number of
+// events, and builds are reproduced replaying the recorded messages.
+// Events are never removed until the value is deleted, so any previous
+// version can be rebuilt. Each key is locked on its own, so different
+// services are read and written in parallel
+type EventStore struct {
+	mu            sync.Mutex
+	locks         map[string]*keyLock
+	store         Store
+	snapshotEvery int
+}
+
+// storedEvent : a change on a value, the message received and the
+// transitions recorded, and the operations to apply to the previous
+// version to get the new one. Its revision is its sequence, so a
+// conditional set on service-store never writes an event twice
+type storedEvent struct {
+	Sequence    int              `json:"sequence"`
+	Revision    int              `json:"revision"`
+	Timestamp   string           `json:"timestamp"`
+	Message     *recordedMessage `json:"message,omitempty"`
+	Transitions []interface{}    `json:"transitions,omitempty"`
+	Ops         []operation      `json:"ops"`
+}
+
+// inboundMessages : the message each service is processing, recorded on
+// the first event it causes, and the transitions each service is being
+// stored with
+type inboundMessages struct {
+	mu          sync.Mutex
+	messages    map[string]*recordedMessage
+	transitions map[string][]interface{}
+}
+
+// inbound : messages being processed by this instance
+var inbound = &inboundMessages{
+	messages:    make(map[string]*recordedMessage),
+	transitions: make(map[string][]interface{}),
+}
+
+// receive : keeps the message processed for a service until the returned
+// function is called
+func (im *inboundMessages) receive(id string, m *nats.Msg) func() {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.messages[id] = &recordedMessage{Subject: m.Subject, Body: rawBody(m.Data)}
+
+	return func() {
+		im.mu.Lock()
+		defer im.mu.Unlock()
+
+		delete(im.messages, id)
+	}
+}
+
+// synthetic : keeps a message the manager generates itself for a service,
+// as its timeouts or retries, until the returned function is called. It is
+// recorded as a received one, so replays go through it too
+func (im *inboundMessages) synthetic(id string, subject string, body map[string]interface{}) func() {
+	data, _ := json.Marshal(body)
+
+	return im.receive(id, &nats.Msg{Subject: subject, Data: data})
+}
+
+// get : gets the message processed for a service, if it was not recorded
+// yet
+func (im *inboundMessages) get(id string) *recordedMessage {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	return im.messages[id]
+}
+
+// transit : keeps the transitions a service is being stored with until
+// the returned function is called, as they are kept on its history log
+func (im *inboundMessages) transit(id string, transitions []interface{}) func() {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.transitions[id] = transitions
+
+	return func() {
+		im.mu.Lock()
+		defer im.mu.Unlock()
+
+		delete(im.transitions, id)
+	}
+}
+
+// transitionsOf : gets the transitions a service is being stored with
+func (im *inboundMessages) transitionsOf(id string) []interface{} {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	return im.transitions[id]
+}
+
+// recorded : marks the message processed for a service as recorded
+func (im *inboundMessages) recorded(id string, m *recordedMessage) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if im.messages[id] == m {
+		delete(im.messages, id)
+	}
+}
+
+// operation : sets, removes or appends values on the given path, an empty
+// path refers to the whole value
+type operation struct {
+	Op     string        `json:"op"`
+	Path   []string      `json:"path"`
+	Value  interface{}   `json:"value,omitempty"`
+	Values []interface{} `json:"values,omitempty"`
+}
+
+// snapshot : a value as it was after the event with the given sequence
+type snapshot struct {
+	Sequence int         `json:"sequence"`
+	Value    interface{} `json:"value"`
+}
+
+// NewEventStore : EventStore constructor
+func NewEventStore(store Store, snapshotEvery int) (*EventStore, error) {
+	if snapshotEvery < 1 {
+		return nil, errors.New("Snapshot interval must be greater than 0")
+	}
+
+	return &EventStore{store: store, snapshotEvery: snapshotEvery, locks: make(map[string]*keyLock)}, nil
+}
+
+// keyLock : lock of a key, released once nobody waits for it
+type keyLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock : locks the given key until the returned function is called
+func (s *EventStore) lock(key string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &keyLock{}
+		s.locks[key] = l
+	}
+	l.users++
+	s.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		l.users--
+		if l.users == 0 {
+			delete(s.locks, key)
+		}
+	}
+}
+
+// Get : rebuilds the current value for the given key
+func (s *EventStore) Get(key string) (string, error) {
+	defer s.lock(key)()
+
+	value, _, err := s.replay(key, -1)
+
+	return value, err
+}
+
+// GetAt : rebuilds the value for the given key as it was after the event
+// with the given sequence
+func (s *EventStore) GetAt(key string, sequence int) (string, error) {
+	defer s.lock(key)()
+
+	value, _, err := s.replay(key, sequence)
+
+	return value, err
+}
+
+// Set : stores a value for the given key
+func (s *EventStore) Set(key string, value string) error {
+	return s.Update(key, func(current string) (string, error) {
+		return value, nil
+	})
+}
+
+// Update : stores the changes between the current value for the given
+// key and the one returned by fn as a new event, along with the message
+// being processed for it. ErrRevisionConflict is returned if another
+// writer stored the event first
+func (s *EventStore) Update(key string, fn func(current string) (string, error)) error {
+	defer s.lock(key)()
+
+	current, sequence, err := s.replay(key, -1)
+	if err != nil {
+		return err
+	}
+	value, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	var before, after interface{}
+	if current != "" {
+		if err := json.Unmarshal([]byte(current), &before); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal([]byte(value), &after); err != nil {
+		return err
+	}
+
+	var ops []operation
+	if current == "" {
+		ops = []operation{{Op: "set", Path: []string{}, Value: after}}
+	} else {
+		diff(before, after, []string{}, &ops)
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	sequence++
+	message := inbound.get(key)
+	event, err := json.Marshal(storedEvent{
+		Sequence:    sequence,
+		Revision:    sequence,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Message:     message,
+		Transitions: inbound.transitionsOf(key),
+		Ops:         ops,
+	})
+	if err != nil {
+		return err
+	}
+	err = s.store.Update(eventKey(key, sequence), func(current string) (string, error) {
+		if current != "" {
+			return "", ErrRevisionConflict
+		}
+		return string(event), nil
+	})
+	if err != nil {
+		return err
+	}
+	inbound.recorded(key, message)
+
+	if sequence == 1 || sequence%s.snapshotEvery == 0 {
+		body, err := json.Marshal(snapshot{Sequence: sequence, Value: after})
+		if err != nil {
+			return err
+		}
+		return s.store.Set(key, string(body))
+	}
+
+	return nil
+}
+
+// Messages : gets the inbound messages recorded for the given key, in the
+// order they were processed, and the value as it was before the first one
+func (s *EventStore) Messages(key string) ([]recordedMessage, string, error) {
+	defer s.lock(key)()
+
+	var messages []recordedMessage
+	var initial string
+	for sequence := 1; ; sequence++ {
+		raw, err := s.store.Get(eventKey(key, sequence))
+		if err != nil {
+			return nil, "", err
+		}
+		if raw == "" {
+			return messages, initial, nil
+		}
+		var event storedEvent
+		if err := json.Unmarshal([]byte(raw), &event); err != nil {
+			return nil, "", err
+		}
+		if event.Message == nil {
+			continue
+		}
+		if len(messages) == 0 && sequence > 1 {
+			if initial, _, err = s.replay(key, sequence-1); err != nil {
+				return nil, "", err
+			}
+		}
+		messages = append(messages, *event.Message)
+	}
+}
+
+// Delete : removes the given key with all its events
+func (s *EventStore) Delete(key string) error {
+	defer s.lock(key)()
+
+	for sequence := 1; ; sequence++ {
+		event, err := s.store.Get(eventKey(key, sequence))
+		if err != nil {
+			return err
+		}
+		if event == "" {
+			break
+		}
+		if err := s.store.Delete(eventKey(key, sequence)); err != nil {
+			return err
+		}
+	}
+
+	return s.store.Delete(key)
+}
+
+// List : gets all stored keys, without their events
+func (s *EventStore) List() ([]string, error) {
+	all, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, k := range all {
+		if !strings.Contains(k, eventKeySeparator) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys, nil
+}
+
+// Close : closes the underlying store, if it can be closed
+func (s *EventStore) Close() error {
+	if c, ok := s.store.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// replay : rebuilds the value for a key applying its events up to the
+// given sequence, or all of them if it is negative, returning the value
+// and the sequence of the last applied event
+func (s *EventStore) replay(key string, until int) (string, int, error) {
+	var value interface{}
+	sequence := 0
+
+	raw, err := s.store.Get(key)
+	if err != nil {
+		return "", 0, err
+	}
+	if raw != "" {
+		var snap snapshot
+		if err := json.Unmarshal([]byte(raw), &snap); err != nil {
+			return "", 0, err
+		}
+		if until < 0 || snap.Sequence <= until {
+			value = snap.Value
+			sequence = snap.Sequence
+		}
+	}
+
+	for until < 0 || sequence < until {
+		raw, err := s.store.Get(eventKey(key, sequence+1))
+		if err != nil {
+			return "", 0, err
+		}
+		if raw == "" {
+			break
+		}
+		var event storedEvent
+		if err := json.Unmarshal([]byte(raw), &event); err != nil {
+			return "", 0, err
+		}
+		for _, o := range event.Ops {
+			value = o.apply(value)
+		}
+		sequence = event.Sequence
+	}
+
+	if sequence == 0 {
+		return "", 0, nil
+	}
+	body, err := json.Marshal(value)
+
+	return string(body), sequence, err
+}
+
+// eventKey : gets the key an event is stored on
+func eventKey(key string, sequence int) string {
+	return key + eventKeySeparator + strconv.Itoa(sequence)
+}
+
+// diff : gets the operations turning a value into another one. Arrays
+// only growing are stored as the appended values, as the service
+// history or its components usually do
+func diff(before interface{}, after interface{}, path []string, ops *[]operation) {
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+
+	switch b := before.(type) {
+	case map[string]interface{}:
+		a, ok := after.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				*ops = append(*ops, operation{Op: "remove", Path: subPath(path, k)})
+			}
+		}
+		for k, v := range a {
+			if old, ok := b[k]; ok {
+				diff(old, v, subPath(path, k), ops)
+			} else {
+				*ops = append(*ops, operation{Op: "set", Path: subPath(path, k), Value: v})
+			}
+		}
+		return
+	case []interface{}:
+		a, ok := after.([]interface{})
+		if !ok || len(a) < len(b) || !reflect.DeepEqual(b, a[:len(b)]) {
+			break
+		}
+		*ops = append(*ops, operation{Op: "append", Path: path, Values: a[len(b):]})
+		return
+	}
+
+	*ops = append(*ops, operation{Op: "set", Path: path, Value: after})
+}
+
+// subPath : gets the path for a key inside the given one
+func subPath(path []string, key string) []string {
+	p := make([]string, len(path), len(path)+1)
+	copy(p, path)
+
+	return append(p, key)
+}
+
+// apply : applies the operation on the given value returning the result
+func (o *operation) apply(value interface{}) interface{} {
+	if len(o.Path) == 0 {
+		switch o.Op {
+		case "set":
+			return o.Value
+		case "append":
+			current, _ := value.([]interface{})
+			return append(current, o.Values...)
+		}
+		return nil
+	}
+
+	parent, ok := value.(map[string]interface{})
+	if !ok {
+		parent = make(map[string]interface{})
+	}
+	key := o.Path[0]
+	if len(o.Path) == 1 && o.Op == "remove" {
+		delete(parent, key)
+		return parent
+	}
+
+	child := operation{Op: o.Op, Path: o.Path[1:], Value: o.Value, Values: o.Values}
+	parent[key] = child.apply(parent[key])
+
+	return parent
+}
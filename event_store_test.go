@@ -0,0 +1,231 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEventStore(t *testing.T) {
+	Convey("Given I have an event store", t, func() {
+		st, err := NewEventStore(NewMemoryStore(), 3)
+		So(err, ShouldBeNil)
+		testStore(st)
+	})
+
+	Convey("Given I have an event store on the nats backend", t, func() {
+		setup()
+		st, err := NewEventStore(NewNatsStore(natsClient), 3)
+		So(err, ShouldBeNil)
+		id := "nats-events-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+		So(st.Set(id, `{"id":"`+id+`","status":"created"}`), ShouldBeNil)
+
+		Convey("When another replica stores the next event while I update it", func() {
+			err := st.Update(id, func(current string) (string, error) {
+				natsClient.Request("service.set.mapping", []byte(`{"id":"`+id+`#2","mapping":"{\"sequence\":2,\"revision\":2,\"ops\":[]}"}`), time.Second)
+				return `{"id":"` + id + `","status":"started"}`, nil
+			})
+
+			Convey("Then the event is not overwritten", func() {
+				So(err, ShouldEqual, ErrRevisionConflict)
+			})
+		})
+	})
+
+	Convey("Given a service persisted as events", t, func() {
+		inner := NewMemoryStore()
+		st, _ := NewEventStore(inner, 3)
+		st.Set("test-generated-id", `{"id":"test-generated-id","status":"created","history":[{"to":"created"}]}`)
+		st.Set("test-generated-id", `{"id":"test-generated-id","status":"started","history":[{"to":"created"},{"to":"started"}]}`)
+
+		Convey("Then only the changes are stored", func() {
+			raw, _ := inner.Get(eventKey("test-generated-id", 2))
+			var event storedEvent
+			So(json.Unmarshal([]byte(raw), &event), ShouldBeNil)
+			So(event.Sequence, ShouldEqual, 2)
+			So(len(event.Ops), ShouldEqual, 2)
+			for _, o := range event.Ops {
+				switch o.Path[0] {
+				case "status":
+					So(o.Op, ShouldEqual, "set")
+					So(o.Value, ShouldEqual, "started")
+				case "history":
+					So(o.Op, ShouldEqual, "append")
+					So(len(o.Values), ShouldEqual, 1)
+				}
+			}
+		})
+
+		Convey("Then the current value is rebuilt", func() {
+			value, err := st.Get("test-generated-id")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, `{"history":[{"to":"created"},{"to":"started"}],"id":"test-generated-id","status":"started"}`)
+		})
+
+		Convey("When it changes after a snapshot", func() {
+			st.Set("test-generated-id", `{"id":"test-generated-id","status":"done"}`)
+			st.Set("test-generated-id", `{"id":"test-generated-id","status":"done","finished":true}`)
+
+			Convey("Then the snapshot is taken", func() {
+				raw, _ := inner.Get("test-generated-id")
+				var snap snapshot
+				So(json.Unmarshal([]byte(raw), &snap), ShouldBeNil)
+				So(snap.Sequence, ShouldEqual, 3)
+			})
+
+			Convey("Then the value is rebuilt from it", func() {
+				value, _ := st.Get("test-generated-id")
+				So(value, ShouldEqual, `{"finished":true,"id":"test-generated-id","status":"done"}`)
+			})
+
+			Convey("Then any previous version can be rebuilt", func() {
+				value, _ := st.GetAt("test-generated-id", 1)
+				So(value, ShouldEqual, `{"history":[{"to":"created"}],"id":"test-generated-id","status":"created"}`)
+			})
+
+			Convey("Then its events are not listed", func() {
+				keys, _ := st.List()
+				So(keys, ShouldResemble, []string{"test-generated-id"})
+			})
+
+			Convey("And it is deleted", func() {
+				st.Delete("test-generated-id")
+
+				Convey("Then none of its events are kept", func() {
+					keys, _ := inner.List()
+					So(keys, ShouldBeEmpty)
+				})
+			})
+		})
+	})
+
+	Convey("Given a service being updated on an event store", t, func() {
+		st, _ := NewEventStore(NewMemoryStore(), 3)
+		st.Set("other-id", `{"id":"other-id"}`)
+		updating := make(chan bool)
+		release := make(chan bool)
+		go st.Update("slow-id", func(current string) (string, error) {
+			updating <- true
+			<-release
+			return `{"id":"slow-id"}`, nil
+		})
+		<-updating
+		defer close(release)
+
+		Convey("When another service is read meanwhile", func() {
+			read := make(chan string)
+			go func() {
+				value, _ := st.Get("other-id")
+				read <- value
+			}()
+
+			Convey("Then it is not blocked by the update", func() {
+				select {
+				case value := <-read:
+					So(value, ShouldEqual, `{"id":"other-id"}`)
+				case <-time.After(time.Second):
+					So("the read was blocked", ShouldBeEmpty)
+				}
+			})
+		})
+	})
+
+	Convey("Given services are persisted as events", t, func() {
+		r := &recorder{}
+		defer sandbox(r)()
+		p.Store, _ = NewEventStore(NewMemoryStore(), 10)
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		So(SaveService(s), ShouldBeNil)
+		loaded := p.getService("test-generated-id")
+		loaded["status"] = "started"
+		So(SaveService(&loaded), ShouldBeNil)
+
+		Convey("When a message is processed", func() {
+			loaded["status"] = "creating_components"
+			loaded["pending_events"] = []interface{}{"components.create"}
+			So(SaveService(&loaded), ShouldBeNil)
+
+			err := processInputMessage(&nats.Msg{
+				Subject: "components.create.done",
+				Data:    h.getFixture("./fixtures/components_create_done.json"),
+			})
+			So(err, ShouldBeNil)
+
+			Convey("Then the message and its transitions are recorded", func() {
+				st := p.Store.(*EventStore)
+				raw, _ := st.store.Get(eventKey("test-generated-id", 4))
+				var event storedEvent
+				So(json.Unmarshal([]byte(raw), &event), ShouldBeNil)
+				So(event.Message.Subject, ShouldEqual, "components.create.done")
+				So(len(event.Transitions), ShouldBeGreaterThan, 0)
+				So(event.Transitions[0].(map[string]interface{})["to"], ShouldEqual, "components_created")
+			})
+
+			Convey("Then the history is not rewritten on the event", func() {
+				st := p.Store.(*EventStore)
+				raw, _ := st.store.Get(eventKey("test-generated-id", 4))
+				var event storedEvent
+				So(json.Unmarshal([]byte(raw), &event), ShouldBeNil)
+				for _, o := range event.Ops {
+					So(o.Path, ShouldNotContain, "history")
+				}
+				history, _, _ := loadHistory("test-generated-id", 0, 0)
+				So(len(history), ShouldBeGreaterThan, 0)
+			})
+
+			Convey("Then the recorded messages can be written to be replayed", func() {
+				var out bytes.Buffer
+				initial, err := writeMessages(p.Store.(*EventStore).store, "test-generated-id", &out)
+				So(err, ShouldBeNil)
+				var m recordedMessage
+				So(json.Unmarshal(out.Bytes(), &m), ShouldBeNil)
+				So(m.Subject, ShouldEqual, "components.create.done")
+
+				Convey("And replayed from the service before them", func() {
+					var service map[string]interface{}
+					So(json.Unmarshal([]byte(initial), &service), ShouldBeNil)
+					So(service["status"], ShouldEqual, "creating_components")
+
+					var steps bytes.Buffer
+					So(replay(&out, service, &steps), ShouldBeNil)
+					var step replayStep
+					So(json.Unmarshal(steps.Bytes(), &step), ShouldBeNil)
+					So(step.Service["status"], ShouldEqual, p.getService("test-generated-id")["status"])
+				})
+			})
+		})
+
+		Convey("When it times out", func() {
+			loaded["timeout"] = map[string]interface{}{"status": "started", "event": "components.create"}
+			So(SaveService(&loaded), ShouldBeNil)
+			manageTimeout("test-generated-id", "started")
+			dp.wait(time.Second)
+
+			Convey("Then the timeout is recorded as a message to be replayed", func() {
+				messages, _, err := p.Store.(*EventStore).Messages("test-generated-id")
+				So(err, ShouldBeNil)
+				last := messages[len(messages)-1]
+				So(last.Subject, ShouldEqual, timeoutSubject)
+				So(string(last.Body), ShouldEqual, `{"id":"test-generated-id","status":"started"}`)
+				So(p.getService("test-generated-id")["status"], ShouldEqual, "errored")
+			})
+		})
+
+		Convey("Then outdated revisions are refused", func() {
+			(*s)["status"] = "errored"
+			So(SaveService(s), ShouldEqual, ErrRevisionConflict)
+			So(p.getService("test-generated-id")["status"], ShouldEqual, "started")
+		})
+	})
+}
@@ -22,6 +22,7 @@ type GenericComponentMsg struct {
 	ErrorMessage         string            `json:"error"`
 	SequentialProcessing bool              `json:"sequential_processing"`
 	Type                 string            `json:"_type"`
+	Traceparent          string            `json:"traceparent,omitempty"`
 }
 
 // NewGenericComponentMsg : GenericComponentMsg constructor
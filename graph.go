@@ -0,0 +1,216 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// graphState : what has to be highlighted when rendering a workflow for
+// a service, its current statuses and the arcs it went through
+type graphState struct {
+	current   map[string]bool
+	traversed map[int]bool
+	visited   map[string]bool
+}
+
+// DOT : renders the workflow as a graphviz dot graph, highlighting the
+// service current status and the path it went through
+func (w *Workflow) DOT(s *map[string]interface{}) string {
+	var b bytes.Buffer
+	g := w.graphState(s)
+
+	b.WriteString("digraph workflow {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+
+	for _, status := range w.statuses() {
+		switch {
+		case g.current[status]:
+			fmt.Fprintf(&b, "  %q [style=\"rounded,filled,bold\", fillcolor=\"gold\"];\n", status)
+		case g.visited[status]:
+			fmt.Fprintf(&b, "  %q [style=\"rounded,filled\", fillcolor=\"lightblue\"];\n", status)
+		default:
+			fmt.Fprintf(&b, "  %q;\n", status)
+		}
+	}
+
+	for i, a := range w.Arcs {
+		attrs := fmt.Sprintf("label=%q", arcLabel(a))
+		if g.traversed[i] {
+			attrs += ", color=\"blue\", penwidth=2"
+		}
+		if a.Branch != "" {
+			attrs += ", style=\"dashed\""
+		}
+		fmt.Fprintf(&b, "  %q -> %q [%s];\n", a.From, a.To, attrs)
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// Mermaid : renders the workflow as a mermaid flowchart, highlighting the
+// service current status and the path it went through
+func (w *Workflow) Mermaid(s *map[string]interface{}) string {
+	var b bytes.Buffer
+	g := w.graphState(s)
+
+	ids := make(map[string]string)
+	statuses := w.statuses()
+	for i, status := range statuses {
+		ids[status] = "s" + strconv.Itoa(i)
+	}
+
+	b.WriteString("graph LR\n")
+	for _, status := range statuses {
+		fmt.Fprintf(&b, "  %s[%s]\n", ids[status], mermaidLabel(status))
+	}
+	for _, a := range w.Arcs {
+		link := "-->"
+		if a.Branch != "" {
+			link = "-.->"
+		}
+		fmt.Fprintf(&b, "  %s %s|%s| %s\n", ids[a.From], link, mermaidLabel(arcLabel(a)), ids[a.To])
+	}
+
+	b.WriteString("  classDef current fill:#ffd700,stroke:#333,stroke-width:2px\n")
+	b.WriteString("  classDef visited fill:#add8e6\n")
+	for _, status := range statuses {
+		switch {
+		case g.current[status]:
+			fmt.Fprintf(&b, "  class %s current\n", ids[status])
+		case g.visited[status]:
+			fmt.Fprintf(&b, "  class %s visited\n", ids[status])
+		}
+	}
+	for i := range w.Arcs {
+		if g.traversed[i] {
+			fmt.Fprintf(&b, "  linkStyle %d stroke:#0000ff,stroke-width:3px\n", i)
+		}
+	}
+
+	return b.String()
+}
+
+// graphState : gets the statuses the service is on and the arcs it went
+// through to reach them
+func (w *Workflow) graphState(s *map[string]interface{}) graphState {
+	g := graphState{
+		current:   make(map[string]bool),
+		traversed: make(map[int]bool),
+		visited:   make(map[string]bool),
+	}
+	if s == nil {
+		return g
+	}
+
+	status, _ := (*s)["status"].(string)
+	if status == "" {
+		return g
+	}
+	g.current[status] = true
+
+	branches, _ := (*s)["branches"].(map[string]interface{})
+	for _, bs := range branches {
+		if bs, ok := bs.(string); ok {
+			g.current[bs] = true
+		}
+	}
+	delete(g.current, "")
+
+	// Services with recorded transitions are drawn through the path they
+	// really went, otherwise the shortest one is assumed
+	if history := transitionHistory(s); len(history) > 0 {
+		w.traceHistory(history, g)
+		return g
+	}
+
+	w.tracePath(status, "", entryStatuses, g)
+	for b, bs := range branches {
+		if bs, ok := bs.(string); ok {
+			w.tracePath(bs, b, []string{status}, g)
+		}
+	}
+
+	return g
+}
+
+// tracePath : marks the shortest path from any of the given statuses to
+// the target one, following only arcs of the given branch, or any arc
+// if no branch is given
+func (w *Workflow) tracePath(target string, branch string, from []string, g graphState) {
+	via := make(map[string]int)
+	reached := make(map[string]bool)
+	pending := append([]string{}, from...)
+	for _, status := range from {
+		reached[status] = true
+	}
+
+	for len(pending) > 0 && !reached[target] {
+		status := pending[0]
+		pending = pending[1:]
+		for i, a := range w.Arcs {
+			if a.From != status || reached[a.To] || (branch != "" && a.Branch != branch) {
+				continue
+			}
+			reached[a.To] = true
+			via[a.To] = i
+			pending = append(pending, a.To)
+		}
+	}
+	if !reached[target] {
+		return
+	}
+
+	for status := target; ; {
+		g.visited[status] = true
+		i, ok := via[status]
+		if !ok {
+			return
+		}
+		g.traversed[i] = true
+		status = w.Arcs[i].From
+	}
+}
+
+// traceHistory : marks the arcs and statuses the service went through
+// according to its recorded transitions
+func (w *Workflow) traceHistory(history []interface{}, g graphState) {
+	for _, h := range history {
+		entry, _ := h.(map[string]interface{})
+		from, _ := entry["from"].(string)
+		to, _ := entry["to"].(string)
+		event, _ := entry["event"].(string)
+
+		g.visited[from] = true
+		g.visited[to] = true
+		for i, a := range w.Arcs {
+			if a.From == from && a.To == to && a.Event == event {
+				g.traversed[i] = true
+			}
+		}
+	}
+}
+
+// arcLabel : gets the label for an arc on a graph
+func arcLabel(a Arc) string {
+	label := a.Event
+	if a.Condition != "" {
+		label += " [" + a.Condition + "]"
+	}
+
+	return label
+}
+
+// mermaidLabel : quotes a mermaid label, its quotes are written as the
+// mermaid entity as mermaid doesn't support escaping them
+func mermaidLabel(label string) string {
+	return `"` + strings.Replace(label, `"`, "#quot;", -1) + `"`
+}
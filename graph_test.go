@@ -0,0 +1,92 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWorkflowGraphs(t *testing.T) {
+	Convey("Given a service waiting for its components to be updated", t, func() {
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "updating_components"
+		w, _ := NewWorkflow(s)
+
+		Convey("When I render it as a dot graph", func() {
+			graph := w.DOT(s)
+
+			Convey("Then the current status is highlighted", func() {
+				So(graph, ShouldStartWith, "digraph workflow {")
+				So(graph, ShouldContainSubstring, `"updating_components" [style="rounded,filled,bold", fillcolor="gold"];`)
+			})
+
+			Convey("Then the path through the workflow is highlighted", func() {
+				So(graph, ShouldContainSubstring, `"created" [style="rounded,filled", fillcolor="lightblue"];`)
+				So(graph, ShouldContainSubstring, `"created" -> "started" [label="service.create", color="blue", penwidth=2];`)
+				So(graph, ShouldContainSubstring, `"components_created" -> "updating_components" [label="components.update", color="blue", penwidth=2];`)
+				So(graph, ShouldContainSubstring, `"updating_components" -> "components_updated" [label="components.update.done"];`)
+				So(graph, ShouldContainSubstring, `"pre-failed" -> "failed" [label="to_error"];`)
+			})
+		})
+
+		Convey("When I render it as a mermaid graph", func() {
+			graph, err := renderGraph(s, "mermaid")
+
+			Convey("Then the current status and path are highlighted", func() {
+				So(err, ShouldBeNil)
+				So(graph, ShouldStartWith, "graph LR\n")
+				So(graph, ShouldContainSubstring, `s0["created"]`)
+				So(graph, ShouldContainSubstring, `s0 -->|"service.create"| s1`)
+				So(graph, ShouldContainSubstring, "class s4 current\n")
+				So(graph, ShouldContainSubstring, "class s0 visited\n")
+				So(graph, ShouldContainSubstring, "linkStyle 0 stroke:#0000ff,stroke-width:3px\n")
+				So(graph, ShouldNotContainSubstring, "linkStyle 4 ")
+			})
+		})
+
+		Convey("When I render a condition with quotes as a mermaid graph", func() {
+			for _, a := range (*s)["workflow"].(map[string]interface{})["arcs"].([]interface{}) {
+				arc := a.(map[string]interface{})
+				if arc["event"] == "components.create" {
+					arc["condition"] = `type=="aws"`
+				}
+			}
+			graph, err := renderGraph(s, "mermaid")
+
+			Convey("Then its quotes are written as mermaid entities", func() {
+				So(err, ShouldBeNil)
+				So(graph, ShouldContainSubstring, `|"components.create [type==#quot;aws#quot;]"|`)
+				So(graph, ShouldNotContainSubstring, `\"`)
+			})
+		})
+
+		Convey("When I render it on an unknown format", func() {
+			_, err := renderGraph(s, "svg")
+
+			Convey("Then I receive an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a service running parallel branches", t, func() {
+		s, _ := h.getService("./fixtures/service_fork.json")
+		em.manageEvents("service.create", s)
+		em.move(s, "networks.create")
+		w, _ := NewWorkflow(s)
+
+		Convey("When I render it as a dot graph", func() {
+			graph := w.DOT(s)
+
+			Convey("Then all branch statuses are highlighted", func() {
+				So(graph, ShouldContainSubstring, `"creating_networks" [style="rounded,filled,bold", fillcolor="gold"];`)
+				So(graph, ShouldContainSubstring, `"started" [style="rounded,filled,bold", fillcolor="gold"];`)
+				So(graph, ShouldContainSubstring, `"started" -> "creating_networks" [label="networks.create", color="blue", penwidth=2, style="dashed"];`)
+			})
+		})
+	})
+}
@@ -0,0 +1,99 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Key read from the storage backend to check it is reachable
+const healthKey = "workflow-manager.healthcheck"
+
+// healthCheck : a named check of a dependency
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// liveness : checks failing only when the manager can't recover by itself
+var liveness = []healthCheck{
+	{"nats", checkNatsOpen},
+}
+
+// readiness : checks failing while the manager can't process messages
+var readiness = []healthCheck{
+	{"nats", checkNatsConnected},
+	{"storage", checkStorage},
+	{"subscriptions", checkSubscriptions},
+}
+
+// checkNatsOpen : checks the nats connection has not been closed, a
+// disconnected connection keeps reconnecting
+func checkNatsOpen() error {
+	if natsClient == nil || natsClient.IsClosed() {
+		return errors.New("nats connection is closed")
+	}
+	return nil
+}
+
+// checkNatsConnected : checks the nats connection is established
+func checkNatsConnected() error {
+	if natsClient == nil || !natsClient.IsConnected() {
+		return errors.New("nats is not connected")
+	}
+	return nil
+}
+
+// checkStorage : checks the storage backend replies
+func checkStorage() error {
+	if p.Store == nil {
+		return errors.New("storage is not loaded")
+	}
+	_, err := p.Store.Get(healthKey)
+
+	return err
+}
+
+// checkSubscriptions : checks the manager is subscribed to the platform
+// messages
+func checkSubscriptions() error {
+	if len(subscriptions) == 0 {
+		return errors.New("not subscribed")
+	}
+	for _, s := range subscriptions {
+		if !s.IsValid() {
+			return errors.New("subscription to " + s.Subject + " is not active")
+		}
+	}
+	return nil
+}
+
+// healthHandler : replies with the result of the given checks, failing
+// if any of them fails
+func healthHandler(checks []healthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := "ok"
+		code := http.StatusOK
+		results := make(map[string]string)
+
+		for _, c := range checks {
+			results[c.name] = "ok"
+			if err := c.check(); err != nil {
+				results[c.name] = err.Error()
+				status = "unavailable"
+				code = http.StatusServiceUnavailable
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": status,
+			"checks": results,
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func getHealth(path string) (int, map[string]interface{}) {
+	var body map[string]interface{}
+
+	w := httptest.NewRecorder()
+	newServeMux().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	json.Unmarshal(w.Body.Bytes(), &body)
+
+	return w.Code, body
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	Convey("Given the manager is connected and subscribed", t, func() {
+		setup()
+		defer withMemoryStore()()
+
+		sub, _ := natsClient.Subscribe("health.test", func(m *nats.Msg) {})
+		subscriptions = []*nats.Subscription{sub}
+		defer func() { subscriptions = nil }()
+
+		Convey("Then it is alive", func() {
+			code, body := getHealth("/healthz")
+			So(code, ShouldEqual, 200)
+			So(body["status"], ShouldEqual, "ok")
+		})
+
+		Convey("Then it is ready", func() {
+			code, body := getHealth("/readyz")
+			So(code, ShouldEqual, 200)
+			checks := body["checks"].(map[string]interface{})
+			So(checks["nats"], ShouldEqual, "ok")
+			So(checks["storage"], ShouldEqual, "ok")
+			So(checks["subscriptions"], ShouldEqual, "ok")
+		})
+
+		Convey("When its subscriptions are not active", func() {
+			sub.Unsubscribe()
+
+			Convey("Then it is not ready", func() {
+				code, body := getHealth("/readyz")
+				So(code, ShouldEqual, 503)
+				So(body["status"], ShouldEqual, "unavailable")
+				So(body["checks"].(map[string]interface{})["subscriptions"], ShouldEqual, "subscription to health.test is not active")
+			})
+
+			Convey("Then it is still alive", func() {
+				code, _ := getHealth("/healthz")
+				So(code, ShouldEqual, 200)
+			})
+		})
+	})
+}
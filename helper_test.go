@@ -10,6 +10,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	ecc "github.com/ernestio/ernest-config-client"
 	"github.com/nats-io/nats"
@@ -73,8 +75,18 @@ func runListenerMocks() {
 		if err != nil {
 			println(err.Error())
 		}
+		if sm.Revision != nil && !strings.HasPrefix(sm.ID, "legacy-") && storedRevision(store[sm.ID]) != *sm.Revision {
+			natsClient.Publish(m.Reply, []byte(revisionConflictReply))
+			return
+		}
 		store[sm.ID] = sm.Mapping
 		manageInputMessage(m)
+		// Ids starting with legacy- are stored as a service-store without
+		// conditional sets would
+		if sm.Revision != nil && !strings.HasPrefix(sm.ID, "legacy-") {
+			natsClient.Publish(m.Reply, []byte(`{"`+revisionCheckedField+`":`+strconv.Itoa(*sm.Revision)+`}`))
+			return
+		}
 		natsClient.Publish(m.Reply, []byte(store[sm.ID]))
 	})
 
@@ -90,4 +102,14 @@ func setup() {
 		runListenerMocks()
 		listeners = true
 	}
+	bus = natsClient
+}
+
+// withMemoryStore : runs a test on a memory store, returning the function
+// restoring the previous one
+func withMemoryStore() func() {
+	previous := p.Store
+	p.Store = NewMemoryStore()
+
+	return func() { p.Store = previous }
 }
@@ -0,0 +1,272 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats"
+	"github.com/tidwall/gjson"
+)
+
+// Subject to request the transition history of a service
+const historySubject = "service.history"
+
+// Identifies this instance on the recorded transitions
+var instanceID = loadInstanceID()
+
+// Number of transitions kept on each page of a service history
+const historyPageSize = 100
+
+// Maximum number of transitions replied on a history request
+const historyMaxLimit = 1000
+
+// historyLog : the header of the history of a service, kept apart from the
+// service as an append only log, with the number of transitions recorded
+type historyLog struct {
+	Count    int `json:"count"`
+	Revision int `json:"revision"`
+}
+
+// historyPage : the transitions of a service history on a page
+type historyPage struct {
+	Entries  []interface{} `json:"entries"`
+	Revision int           `json:"revision"`
+}
+
+// loadInstanceID : gets the instance id from the INSTANCE_ID environment
+// variable, defaulting to the hostname and process id
+func loadInstanceID() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	hostname, _ := os.Hostname()
+
+	return hostname + ":" + strconv.Itoa(os.Getpid())
+}
+
+// recordTransition : appends a transition to the ones recorded on the
+// service history field since it was loaded, failed transitions record the
+// service error. The received subject is set once the service is
+// persisted, which moves them to the history log
+func recordTransition(s *map[string]interface{}, from string, to string, event string, branch string) {
+	entry := map[string]interface{}{
+		"from":      from,
+		"to":        to,
+		"event":     event,
+		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		"instance":  instanceID,
+	}
+	if branch != "" {
+		entry["branch"] = branch
+	}
+	if to == "pre-failed" {
+		if err, ok := (*s)["last_known_error"].(string); ok && err != "" {
+			entry["error"] = err
+		}
+	}
+
+	id, _ := (*s)["id"].(string)
+	sp := tracing.start(id, "fsm.transition", spanInternal)
+	sp.set("fsm.from", from).set("fsm.to", to).set("fsm.event", event)
+	defer sp.finish()
+
+	history, _ := (*s)["history"].([]interface{})
+	(*s)["history"] = append(history, entry)
+	transitions.WithLabelValues(from, to).Inc()
+	logger.info("transition", serviceFields(s).with("status_from", from).with("status_to", to).with("event", event))
+}
+
+// stampTransitions : sets the received subject on the transitions it
+// caused
+func stampTransitions(s *map[string]interface{}, received string) {
+	history, _ := (*s)["history"].([]interface{})
+	for i := len(history) - 1; i >= 0; i-- {
+		entry, _ := history[i].(map[string]interface{})
+		if _, ok := entry["subject"]; ok {
+			return
+		}
+		entry["subject"] = received
+	}
+}
+
+// historyKey : gets the key the history log of a service is stored on
+func historyKey(id string) string {
+	return id + eventKeySeparator + "history"
+}
+
+// historyPageKey : gets the key a page of the history of a service is
+// stored on
+func historyPageKey(id string, page int) string {
+	return historyKey(id) + eventKeySeparator + strconv.Itoa(page)
+}
+
+// appendHistory : appends transitions to the history log of a service,
+// reserving their positions on its header before writing them on pages of
+// historyPageSize transitions, so no transition is ever rewritten
+func appendHistory(id string, transitions []interface{}) error {
+	if len(transitions) == 0 {
+		return nil
+	}
+	st := p.historyStore()
+
+	var start int
+	err := st.Update(historyKey(id), func(current string) (string, error) {
+		var h historyLog
+		if current != "" {
+			if err := json.Unmarshal([]byte(current), &h); err != nil {
+				return "", err
+			}
+		}
+		start = h.Count
+		h.Count += len(transitions)
+		h.Revision++
+		body, err := json.Marshal(h)
+		return string(body), err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(transitions); {
+		page := (start + i) / historyPageSize
+		end := i + (page+1)*historyPageSize - (start + i)
+		if end > len(transitions) {
+			end = len(transitions)
+		}
+		entries := transitions[i:end]
+		err := st.Update(historyPageKey(id, page), func(current string) (string, error) {
+			var pg historyPage
+			if current != "" {
+				if err := json.Unmarshal([]byte(current), &pg); err != nil {
+					return "", err
+				}
+			}
+			pg.Entries = append(pg.Entries, entries...)
+			pg.Revision++
+			body, err := json.Marshal(pg)
+			return string(body), err
+		})
+		if err != nil {
+			return err
+		}
+		i = end
+	}
+
+	return nil
+}
+
+// loadHistory : gets the transitions of a service history from the given
+// offset, up to the given limit or all of them if it is not positive,
+// along with the number of transitions recorded
+func loadHistory(id string, offset int, limit int) ([]interface{}, int, error) {
+	st := p.historyStore()
+	entries := []interface{}{}
+
+	// Services rendered offline have no stored history
+	if st == nil {
+		return entries, 0, nil
+	}
+	raw, err := st.Get(historyKey(id))
+	if err != nil || raw == "" {
+		return entries, 0, err
+	}
+	var h historyLog
+	if err := json.Unmarshal([]byte(raw), &h); err != nil {
+		return entries, 0, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	end := h.Count
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+
+	for page := offset / historyPageSize; page*historyPageSize < end; page++ {
+		raw, err := st.Get(historyPageKey(id, page))
+		if err != nil {
+			return entries, h.Count, err
+		}
+		var pg historyPage
+		if raw != "" {
+			if err := json.Unmarshal([]byte(raw), &pg); err != nil {
+				return entries, h.Count, err
+			}
+		}
+		for i, e := range pg.Entries {
+			if position := page*historyPageSize + i; position >= offset && position < end {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	return entries, h.Count, nil
+}
+
+// transitionHistory : gets the whole history of a service, the stored
+// transitions followed by the ones recorded since it was loaded
+func transitionHistory(s *map[string]interface{}) []interface{} {
+	id, _ := (*s)["id"].(string)
+	history, _, err := loadHistory(id, 0, 0)
+	if err != nil {
+		logger.error("can't load history", serviceFields(s).with("error", err))
+	}
+	recorded, _ := (*s)["history"].([]interface{})
+
+	return append(history, recorded...)
+}
+
+// serviceHistory : gets the status and a page of the transition history
+// of a service, from the given offset up to the given limit
+func serviceHistory(s map[string]interface{}, offset int, limit int) (map[string]interface{}, error) {
+	if limit <= 0 || limit > historyMaxLimit {
+		limit = historyMaxLimit
+	}
+	id, _ := s["id"].(string)
+	history, total, err := loadHistory(id, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"id":      s["id"],
+		"status":  s["status"],
+		"history": history,
+		"offset":  offset,
+		"total":   total,
+	}, nil
+}
+
+// Replies with the transition history of the requested service
+func manageHistoryRequest(m *nats.Msg) {
+	if m.Reply == "" {
+		return
+	}
+
+	mm := MessageManager{}
+	reply := map[string]interface{}{"error": "not found"}
+
+	if id, err := mm.getServiceID(m.Data); err == nil {
+		if s := p.getService(id); s != nil {
+			offset := int(gjson.GetBytes(m.Data, "offset").Int())
+			limit := int(gjson.GetBytes(m.Data, "limit").Int())
+			if reply, err = serviceHistory(s, offset, limit); err != nil {
+				reply = map[string]interface{}{"error": err.Error()}
+			}
+		}
+	}
+
+	body, err := json.Marshal(reply)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	bus.Publish(m.Reply, body)
+}
@@ -0,0 +1,203 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTransitionHistory(t *testing.T) {
+	Convey("Given a service moving through its workflow", t, func() {
+		s, _ := h.getService("./fixtures/service_components.json")
+		em.move(s, "service.create")
+		em.move(s, "components.create")
+		stampTransitions(s, "service.create")
+
+		Convey("Then its transitions are recorded", func() {
+			history := (*s)["history"].([]interface{})
+			So(len(history), ShouldEqual, 2)
+			first := history[0].(map[string]interface{})
+			So(first["from"], ShouldEqual, "created")
+			So(first["to"], ShouldEqual, "started")
+			So(first["event"], ShouldEqual, "service.create")
+			So(first["subject"], ShouldEqual, "service.create")
+			So(first["instance"], ShouldEqual, instanceID)
+			So(first["timestamp"], ShouldNotBeEmpty)
+			So(history[1].(map[string]interface{})["to"], ShouldEqual, "creating_components")
+		})
+
+		Convey("When its components fail", func() {
+			em := ErrorManager{}
+			em.markAsFailed(s, "components.create.error", h.getFixture("./fixtures/components_create_error.json"))
+			stampTransitions(s, "components.create.error")
+
+			Convey("Then the failure is recorded with its error", func() {
+				history := (*s)["history"].([]interface{})
+				last := history[len(history)-1].(map[string]interface{})
+				So(last["from"], ShouldEqual, "creating_components")
+				So(last["to"], ShouldEqual, "pre-failed")
+				So(last["error"], ShouldEqual, "Rate limit exceeded")
+				So(last["subject"], ShouldEqual, "components.create.error")
+				So(history[0].(map[string]interface{})["subject"], ShouldEqual, "service.create")
+			})
+		})
+
+		Convey("When its build is finished", func() {
+			(*s)["pending_events"] = []interface{}{"components.create"}
+			(*s)["revision"] = 3
+			pub := Publisher{}
+			body := pub.FinishProcessing(s, "done")
+
+			Convey("Then the bookkeeping fields are not sent", func() {
+				var sent map[string]interface{}
+				So(json.Unmarshal([]byte(body), &sent), ShouldBeNil)
+				So(sent["status"], ShouldEqual, "done")
+				for _, field := range bookkeepingFields {
+					So(sent, ShouldNotContainKey, field)
+				}
+				So((*s)["history"], ShouldNotBeNil)
+			})
+		})
+
+		Convey("When its graph is rendered", func() {
+			w, _ := NewWorkflow(s)
+			g := w.graphState(s)
+
+			Convey("Then the recorded path is highlighted", func() {
+				So(g.current["creating_components"], ShouldBeTrue)
+				So(g.visited["started"], ShouldBeTrue)
+				So(g.traversed[0], ShouldBeTrue)
+				So(g.traversed[1], ShouldBeTrue)
+				So(g.traversed[2], ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given a forked service", t, func() {
+		s, _ := h.getService("./fixtures/service_fork.json")
+		(*s)["status"] = "started"
+		w, _ := NewWorkflow(s)
+		em.fork(s, &w)
+		em.move(s, "networks.create")
+
+		Convey("Then the branch transition is recorded", func() {
+			history := (*s)["history"].([]interface{})
+			last := history[len(history)-1].(map[string]interface{})
+			So(last["branch"], ShouldEqual, "networks")
+			So(last["to"], ShouldEqual, "creating_networks")
+		})
+	})
+}
+
+func TestHistoryRequest(t *testing.T) {
+	Convey("Given a persisted service with history", t, func() {
+		setup()
+		defer withMemoryStore()()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		em.move(s, "service.create")
+		stampTransitions(s, "service.create")
+		SaveService(s)
+
+		replies := make(chan *nats.Msg, 1)
+		sub, _ := natsClient.Subscribe("history.reply", func(m *nats.Msg) {
+			replies <- m
+		})
+		defer sub.Unsubscribe()
+
+		Convey("When its history is requested", func() {
+			manageHistoryRequest(&nats.Msg{
+				Subject: historySubject,
+				Reply:   "history.reply",
+				Data:    []byte(`{"id":"test-generated-id"}`),
+			})
+
+			Convey("Then its transitions are replied", func() {
+				var reply struct {
+					ID      string                   `json:"id"`
+					Status  string                   `json:"status"`
+					History []map[string]interface{} `json:"history"`
+				}
+				select {
+				case m := <-replies:
+					So(json.Unmarshal(m.Data, &reply), ShouldBeNil)
+				case <-time.After(time.Second):
+					So("history was not replied", ShouldBeEmpty)
+				}
+				So(reply.ID, ShouldEqual, "test-generated-id")
+				So(reply.Status, ShouldEqual, "started")
+				So(len(reply.History), ShouldEqual, 1)
+				So(reply.History[0]["event"], ShouldEqual, "service.create")
+			})
+		})
+
+		Convey("Then the history is kept apart from the service", func() {
+			So(p.getService("test-generated-id")["history"], ShouldBeNil)
+			So((*s)["history"], ShouldBeNil)
+		})
+
+		Convey("When it records more transitions than a history page", func() {
+			for i := 0; i < historyPageSize+10; i++ {
+				recordTransition(s, "started", "started", "service.patch", "")
+			}
+			So(SaveService(s), ShouldBeNil)
+
+			Convey("Then none of them is dropped", func() {
+				history, total, err := loadHistory("test-generated-id", 0, 0)
+				So(err, ShouldBeNil)
+				So(total, ShouldEqual, historyPageSize+11)
+				So(len(history), ShouldEqual, historyPageSize+11)
+				So(history[0].(map[string]interface{})["event"], ShouldEqual, "service.create")
+			})
+
+			Convey("Then its history is replied by pages", func() {
+				manageHistoryRequest(&nats.Msg{
+					Subject: historySubject,
+					Reply:   "history.reply",
+					Data:    []byte(`{"id":"test-generated-id","offset":99,"limit":5}`),
+				})
+
+				var reply struct {
+					Offset  int                      `json:"offset"`
+					Total   int                      `json:"total"`
+					History []map[string]interface{} `json:"history"`
+				}
+				select {
+				case m := <-replies:
+					So(json.Unmarshal(m.Data, &reply), ShouldBeNil)
+				case <-time.After(time.Second):
+					So("history was not replied", ShouldBeEmpty)
+				}
+				So(reply.Offset, ShouldEqual, 99)
+				So(reply.Total, ShouldEqual, historyPageSize+11)
+				So(len(reply.History), ShouldEqual, 5)
+				So(reply.History[0]["event"], ShouldEqual, "service.patch")
+			})
+		})
+
+		Convey("When the history of an unknown service is requested", func() {
+			manageHistoryRequest(&nats.Msg{
+				Subject: historySubject,
+				Reply:   "history.reply",
+				Data:    []byte(`{"id":"unknown"}`),
+			})
+
+			Convey("Then it is not found", func() {
+				select {
+				case m := <-replies:
+					So(string(m.Data), ShouldEqual, `{"error":"not found"}`)
+				case <-time.After(time.Second):
+					So("history was not replied", ShouldBeEmpty)
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Log levels, lines below the configured one are discarded
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// Fields : values attached to a log line, as service_id, subject,
+// status_from, status_to or correlation_id
+type Fields map[string]interface{}
+
+// jsonLogger : writes a json object per log line
+type jsonLogger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level int
+}
+
+// logger : the manager logger, its level is defined by LOG_LEVEL
+var logger = newLogger(os.Stderr, os.Getenv("LOG_LEVEL"))
+
+// newLogger : jsonLogger constructor, defaults to the info level
+func newLogger(out io.Writer, level string) *jsonLogger {
+	l := &jsonLogger{out: out, level: levelInfo}
+	for i, name := range levelNames {
+		if strings.EqualFold(level, name) {
+			l.level = i
+		}
+	}
+
+	return l
+}
+
+func (l *jsonLogger) debug(msg string, f Fields) { l.log(levelDebug, msg, f) }
+func (l *jsonLogger) info(msg string, f Fields)  { l.log(levelInfo, msg, f) }
+func (l *jsonLogger) warn(msg string, f Fields)  { l.log(levelWarn, msg, f) }
+func (l *jsonLogger) error(msg string, f Fields) { l.log(levelError, msg, f) }
+
+// log : writes a line with the given level, message and fields
+func (l *jsonLogger) log(level int, msg string, f Fields) {
+	if level < l.level {
+		return
+	}
+
+	line := make(map[string]interface{}, len(f)+3)
+	for k, v := range f {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		line[k] = v
+	}
+	line["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line["level"] = levelNames[level]
+	line["msg"] = msg
+
+	body, err := json.Marshal(line)
+	if err != nil {
+		body, _ = json.Marshal(map[string]string{"level": "error", "msg": "can't log " + msg + " : " + err.Error()})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(body, '\n'))
+}
+
+// Write : logs the lines written by the standard log package, so they
+// are json too
+func (l *jsonLogger) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimSpace(p), []byte("\n")) {
+		msg := string(line)
+		if strings.HasPrefix(msg, "[ERROR]") {
+			l.error(strings.TrimLeft(strings.TrimPrefix(msg, "[ERROR]"), " :"), nil)
+		} else {
+			l.info(msg, nil)
+		}
+	}
+
+	return len(p), nil
+}
+
+// serviceFields : gets the fields identifying a service on the logs
+func serviceFields(s *map[string]interface{}) Fields {
+	f := Fields{}
+	if s == nil || *s == nil {
+		return f
+	}
+	if id, ok := (*s)["id"].(string); ok {
+		f["service_id"] = id
+	}
+	if cid, ok := (*s)["correlation_id"].(string); ok {
+		f["correlation_id"] = cid
+	}
+
+	return f
+}
+
+// with : adds a field to a copy of the fields
+func (f Fields) with(key string, value interface{}) Fields {
+	c := make(Fields, len(f)+1)
+	for k, v := range f {
+		c[k] = v
+	}
+	c[key] = value
+
+	return c
+}
+
+// setCorrelationID : identifies all logs of a service build, using the
+// correlation_id given on the message starting it or a new one
+func setCorrelationID(s *map[string]interface{}, body []byte) {
+	if cid := gjson.GetBytes(body, "correlation_id").String(); cid != "" {
+		(*s)["correlation_id"] = cid
+		return
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	(*s)["correlation_id"] = hex.EncodeToString(id)
+}
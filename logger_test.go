@@ -0,0 +1,93 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func logLines(out *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	dec := json.NewDecoder(out)
+	for dec.More() {
+		var line map[string]interface{}
+		if err := dec.Decode(&line); err != nil {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestLogger(t *testing.T) {
+	Convey("Given I have a logger on the warn level", t, func() {
+		var out bytes.Buffer
+		l := newLogger(&out, "WARN")
+
+		Convey("When I log a service failure", func() {
+			s, _ := h.getService("./fixtures/service_components.json")
+			(*s)["correlation_id"] = "abc"
+			l.info("ignored", nil)
+			l.error("failed", serviceFields(s).with("subject", "components.create.error").with("error", errors.New("boom")))
+			lines := logLines(&out)
+
+			Convey("Then only lines over the level are written as json", func() {
+				So(len(lines), ShouldEqual, 1)
+				So(lines[0]["level"], ShouldEqual, "error")
+				So(lines[0]["msg"], ShouldEqual, "failed")
+				So(lines[0]["service_id"], ShouldEqual, "test-generated-id")
+				So(lines[0]["correlation_id"], ShouldEqual, "abc")
+				So(lines[0]["subject"], ShouldEqual, "components.create.error")
+				So(lines[0]["error"], ShouldEqual, "boom")
+				So(lines[0]["time"], ShouldNotBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given the standard log writes on the logger", t, func() {
+		var out bytes.Buffer
+		std := log.New(newLogger(&out, ""), "", 0)
+
+		Convey("When a line is written", func() {
+			std.Println("[ERROR] : something failed")
+			std.Println("[RETRIED] instances.create")
+			lines := logLines(&out)
+
+			Convey("Then it is written as json with its level", func() {
+				So(len(lines), ShouldEqual, 2)
+				So(lines[0]["level"], ShouldEqual, "error")
+				So(lines[0]["msg"], ShouldEqual, "something failed")
+				So(lines[1]["level"], ShouldEqual, "info")
+				So(lines[1]["msg"], ShouldEqual, "[RETRIED] instances.create")
+			})
+		})
+	})
+
+	Convey("Given a service is created", t, func() {
+		s := map[string]interface{}{}
+
+		Convey("When the message has a correlation id", func() {
+			setCorrelationID(&s, []byte(`{"id":"test","correlation_id":"abc"}`))
+
+			Convey("Then it is used", func() {
+				So(s["correlation_id"], ShouldEqual, "abc")
+			})
+		})
+
+		Convey("When the message has no correlation id", func() {
+			setCorrelationID(&s, []byte(`{"id":"test"}`))
+
+			Convey("Then a new one is generated", func() {
+				So(len(s["correlation_id"].(string)), ShouldEqual, 32)
+			})
+		})
+	})
+}
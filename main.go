@@ -5,9 +5,10 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"os"
-	"runtime"
+	"time"
 
 	ecc "github.com/ernestio/ernest-config-client"
 	"github.com/nats-io/nats"
@@ -17,62 +18,315 @@ var natsClient *nats.Conn
 var em = eventManager{}
 var p = storage{}
 var cfg *ecc.Config
+var dp = newDispatcher()
+var sh = sharding{Shards: 1, Group: "workflow-manager"}
+var timeouts *timeoutScheduler
+var retries *timeoutScheduler
+var subscriptions []*nats.Subscription
 
-// Receives a message, updates the related service on the FSM
-// and emits the relative message
+func init() {
+	timeouts = newTimeoutScheduler("timeout", manageTimeout)
+	retries = newTimeoutScheduler("retry", manageRetry)
+}
+
+// Times a message will be processed again when its service has been
+// modified by someone else while it was being processed
+const maxConflictRetries = 5
+
+// Receives a message and queues it on its service mailbox, so messages
+// for the same service are processed in order
 func manageInputMessage(m *nats.Msg) {
-	var service map[string]interface{}
 	mm := MessageManager{}
+	id, _ := mm.getServiceID(m.Data)
+
+	dp.dispatch(id, func() {
+		retryOnConflict(m.Subject, func() error {
+			return processInputMessage(m)
+		})
+	})
+}
+
+// Runs a job on a service again while it fails because the service was
+// modified meanwhile
+func retryOnConflict(name string, job func() error) {
+	for i := 0; i < maxConflictRetries; i++ {
+		if err := job(); err != ErrRevisionConflict {
+			return
+		}
+		logger.warn("conflict", Fields{"subject": name})
+	}
+	logger.error("too many conflicts", Fields{"subject": name})
+}
+
+// Updates the related service on the FSM and emits the relative
+// message. Returns ErrRevisionConflict if the service could not be
+// persisted as it was modified meanwhile
+func processInputMessage(m *nats.Msg) (err error) {
+	mm := MessageManager{}
+	id, _ := mm.getServiceID(m.Data)
+	defer inbound.receive(id, m)()
+
+	sp := tracing.startRemote(id, "process "+m.Subject, spanConsumer, messageTraceparent(m.Data))
+	sp.set("messaging.destination", m.Subject)
+	defer func() {
+		finishSpans([]*span{sp}, err)
+	}()
 
+	sub := tracing.start(id, "subscriber", spanInternal)
 	service, subject, err := mm.getServiceFromMessage(m.Subject, m.Data)
+	finishSpans([]*span{sub}, err)
+	if verr, ok := err.(*ValidationError); ok {
+		messagesRejected.WithLabelValues(messageKind(m.Subject), "invalid_workflow").Inc()
+		rejectService(m, &service, verr)
+		return nil
+	}
+	if err != nil {
+		messagesRejected.WithLabelValues(messageKind(m.Subject), "unsupported").Inc()
+		return nil
+	}
+	if subject == retrySubject {
+		err = scheduleRetry(&service)
+	} else {
+		err = advance(&service, subject, m.Subject)
+	}
 	if err == nil {
-		subject, err := em.manage(subject, &service)
-		if err := SaveService(&service); err != nil {
-			log.Println("[ERROR] : " + err.Error())
+		messagesProcessed.WithLabelValues(m.Subject).Inc()
+	}
+
+	return err
+}
+
+// Moves the service with the given subject, persists it and emits the
+// next events on its workflow. Failed services are compensated first if
+// their workflow defines how
+func advance(service *map[string]interface{}, subject string, received string) error {
+	// A service moving on is no longer interrupted
+	delete(*service, "interrupted")
+	if cancelling(service) {
+		return advanceCancel(service, subject, received)
+	}
+	status, _ := (*service)["status"].(string)
+
+	if status == compensatingStatus || (subject == "to_error" && startCompensation(service)) {
+		event, finished := compensate(service, subject)
+		if !finished {
+			var events []string
+			if event != "" {
+				events = append(events, event)
+			}
+			return emit(service, events, false, received)
 		}
-		message, err := mm.preparePublishMessage(subject, &service)
+		subject = "to_error"
+	}
+
+	events, _ := em.manageEvents(subject, service)
+	if len(events) > 0 && paused(service) {
+		hold(service, events)
+		events = nil
+	}
+
+	return emit(service, events, true, received)
+}
 
+// Prepares the messages for the given events, moving the service through
+// them if required, persists the service and publishes them
+func emit(service *map[string]interface{}, events []string, move bool, received string) error {
+	mm := MessageManager{}
+
+	messages := make(map[string]string)
+	spans := make(map[string]*span)
+	parent, _ := (*service)[traceparentField].(string)
+	for _, event := range events {
+		sp := publishSpan(service, event, parent)
+		message, err := mm.preparePublishMessage(event, service)
 		if err != nil {
-			log.Println(err)
+			logger.error("can't prepare message", serviceFields(service).with("subject", event).with("error", err))
+			finishSpans([]*span{sp}, err)
+			continue
+		}
+		spans[event] = sp
+		if move {
+			em.move(service, event)
+		}
+		messages[event] = message
+	}
+	if len(messages) > 0 {
+		logger.info("processed", serviceFields(service).with("subject", received))
+	}
+	var emitted []string
+	for _, event := range events {
+		if _, ok := messages[event]; ok {
+			emitted = append(emitted, event)
+		}
+	}
+	trackPendingEvents(service, received, emitted)
+	stampTransitions(service, received)
+
+	if err := SaveService(service); err != nil {
+		for _, sp := range spans {
+			finishSpans([]*span{sp}, err)
+		}
+		return err
+	}
+	inFlight.track(service)
+	timeouts.schedule(service)
+	retries.schedule(service)
+
+	for _, event := range events {
+		if message, ok := messages[event]; ok {
+			finishSpans([]*span{spans[event]}, bus.Publish(event, []byte(message)))
+			logger.info("emitted", serviceFields(service).with("subject", event))
+		}
+	}
+
+	return nil
+}
+
+// Rejects a service with an invalid workflow definition, replying with
+// the found issues and sending the relative error event
+func rejectService(m *nats.Msg, s *map[string]interface{}, verr *ValidationError) {
+	id, _ := (*s)["id"].(string)
+	logger.warn("rejected", serviceFields(s).with("subject", m.Subject).with("error", verr))
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":     id,
+		"status": "errored",
+		"error":  verr.Error(),
+		"errors": verr.Issues,
+	})
+	if err != nil {
+		logger.error("can't reply rejection", serviceFields(s).with("error", err))
+		return
+	}
+
+	bus.Request("service.set", []byte(`{"id":"`+id+`","status":"errored"}`), time.Second)
+	if m.Reply != "" {
+		bus.Publish(m.Reply, body)
+	}
+	bus.Publish(m.Subject+".error", body)
+}
+
+// Removes a service once its deletion is done
+func manageServiceDeletion(m *nats.Msg) {
+	mm := MessageManager{}
+	id, _ := mm.getServiceID(m.Data)
+
+	dp.dispatch(id, func() {
+		s, err := mm.getService(m.Data)
+		if err != nil {
+			logger.warn("service not found", Fields{"service_id": id, "subject": m.Subject})
 		} else {
-			em.move(&service, subject)
-			log.Println("[PROCESSED]", m.Subject)
-			if err := SaveService(&service); err != nil {
-				log.Println("[ERROR] : " + err.Error())
-			}
-			natsClient.Publish(subject, []byte(message))
-			log.Println("[EMITTED]", subject)
+			ServiceDel(&s)
 		}
+	})
+}
+
+// Handles a message owned by this instance
+func handleInputMessage(m *nats.Msg) {
+	switch m.Subject {
+	case resumeSubject:
+		manageServiceResume(m)
+		return
+	case historySubject:
+		manageHistoryRequest(m)
+		return
+	case cancelSubject:
+		manageServiceCancel(m)
+		return
+	case pauseSubject, unpauseSubject:
+		manageServicePause(m)
+		return
+	}
+
+	manageInputMessage(m)
+
+	if m.Subject == "service.delete.done" {
+		manageServiceDeletion(m)
 	}
-	service = nil
+}
+
+// Handles the message if its service is owned by this instance, or
+// forwards it to the owner shard otherwise
+func routeInputMessage(m *nats.Msg) {
+	mm := MessageManager{}
+	id, err := mm.getServiceID(m.Data)
+	if err != nil || sh.owns(id) {
+		handleInputMessage(m)
+		return
+	}
+
+	if err := sh.forward(id, m); err != nil {
+		logger.error("can't forward message", Fields{"service_id": id, "subject": m.Subject, "error": err})
+	}
+}
+
+// Subscribes to a subject on the instances queue group, keeping the
+// subscription
+func subscribe(subject string, handler nats.MsgHandler) {
+	s, err := natsClient.QueueSubscribe(subject, sh.Group, handler)
+	if err != nil {
+		logger.error("can't subscribe", Fields{"subject": subject, "error": err})
+		return
+	}
+	subscriptions = append(subscriptions, s)
+}
+
+// Subscribes to the given subject out of the queue group, so every
+// instance receives its messages
+func broadcast(subject string, handler nats.MsgHandler) {
+	s, err := natsClient.Subscribe(subject, handler)
+	if err != nil {
+		logger.error("can't subscribe", Fields{"subject": subject, "error": err})
+		return
+	}
+	subscriptions = append(subscriptions, s)
 }
 
 // Setup the listeners for all messages on the platform
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// Any other log line is written as json too
+	log.SetFlags(0)
+	log.SetOutput(logger)
+
 	cfg = ecc.NewConfig(os.Getenv("NATS_URI"))
 	natsClient = cfg.Nats()
+	bus = natsClient
 	p.load(natsClient)
+	sh = loadSharding()
+	t, err := loadTracer()
+	if err != nil {
+		log.Panic("Invalid tracing configuration : " + err.Error())
+	}
+	tracing = t
+	go serveHTTP()
+	go serveAdmin()
 
 	// Messages matching *.* are always actions
-	natsClient.Subscribe("*.*", func(m *nats.Msg) {
-		manageInputMessage(m)
-	})
+	subscribe("*.*", routeInputMessage)
 
 	// Messages with *.*.* are results
-	natsClient.Subscribe("*.*.*", func(m *nats.Msg) {
-		manageInputMessage(m)
-	})
+	subscribe("*.*.*", routeInputMessage)
 
-	// Service delete
-	natsClient.Subscribe("service.delete.done", func(m *nats.Msg) {
-		mm := MessageManager{}
-		s, err := mm.getService(m.Data)
+	// Messages forwarded by other instances for services on this shard
+	subscribe(sh.subject(sh.Shard), func(m *nats.Msg) {
+		msg, err := sh.unwrap(m)
 		if err != nil {
-			log.Println("Service not found")
-		} else {
-			ServiceDel(&s)
+			logger.error("invalid forwarded message", Fields{"subject": m.Subject, "error": err})
+			return
 		}
+		handleInputMessage(msg)
 	})
 
-	runtime.Goexit()
+	// Pauses and unpauses of all services
+	loadGlobalPause()
+	broadcast(globalPauseSubject, manageGlobalPause)
+
+	// Services interrupted while waiting for a result
+	recoverServices(os.Getenv("RECOVERY_POLICY"))
+
+	waitForShutdown()
 }
@@ -0,0 +1,78 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore : keeps services in memory, mostly useful for development
+// and tests as nothing survives a restart
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data map[string]string
+}
+
+// NewMemoryStore : MemoryStore constructor
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string]string)}
+}
+
+// Get : gets the value stored for the given key
+func (s *MemoryStore) Get(key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.data[key], nil
+}
+
+// Set : stores a value for the given key
+func (s *MemoryStore) Set(key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[key] = value
+
+	return nil
+}
+
+// Update : replaces the value for the given key with the one returned by fn
+func (s *MemoryStore) Update(key string, fn func(current string) (string, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, err := fn(s.data[key])
+	if err != nil {
+		return err
+	}
+	s.data[key] = value
+
+	return nil
+}
+
+// Delete : removes the given key
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data, key)
+
+	return nil
+}
+
+// List : gets all stored keys
+func (s *MemoryStore) List() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
@@ -40,6 +40,9 @@ func (mm *MessageManager) getServiceFromMessage(subject string, body []byte) (ma
 
 	if status != "" {
 		em := ErrorManager{}
+		if em.markForRetry(&s, subject, body) {
+			return s, retrySubject, nil
+		}
 		em.markAsFailed(&s, subject, body)
 		return s, status, nil
 	}
@@ -48,6 +51,12 @@ func (mm *MessageManager) getServiceFromMessage(subject string, body []byte) (ma
 		return nil, "", errors.New("Message not supported")
 	}
 
+	if subject == "service.create" || subject == "service.import" {
+		if err := ValidateWorkflow(&s); err != nil {
+			return s, subject, err
+		}
+	}
+
 	return s, subject, nil
 }
 
@@ -72,6 +81,18 @@ func (mm *MessageManager) validateSubject(subject string) error {
 // Creates or gets a persisted service based on the service field of the
 // message body
 func (mm *MessageManager) getService(body []byte) (map[string]interface{}, error) {
+	serviceID, err := mm.getServiceID(body)
+	if err != nil {
+		return nil, err
+	}
+
+	s := p.getService(serviceID)
+
+	return s, nil
+}
+
+// Gets the id of the service a message body refers to
+func (mm *MessageManager) getServiceID(body []byte) (string, error) {
 	type InputMessage struct {
 		ID      string `json:"id"`
 		Service string `json:"service"`
@@ -79,7 +100,7 @@ func (mm *MessageManager) getService(body []byte) (map[string]interface{}, error
 
 	m := InputMessage{}
 	if err := json.Unmarshal(body, &m); err != nil {
-		return nil, err
+		return "", err
 	}
 
 	serviceID := m.Service
@@ -87,10 +108,8 @@ func (mm *MessageManager) getService(body []byte) (map[string]interface{}, error
 		serviceID = m.ID
 	}
 	if serviceID == "" {
-		return nil, errors.New("Unsupported message")
+		return "", errors.New("Unsupported message")
 	}
 
-	s := p.getService(serviceID)
-
-	return s, nil
+	return serviceID, nil
 }
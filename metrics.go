@@ -0,0 +1,130 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	messagesProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "workflow_manager_messages_processed_total",
+		Help: "Messages processed, by subject",
+	}, []string{"subject"})
+
+	messagesRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "workflow_manager_messages_rejected_total",
+		Help: "Messages rejected, by kind of subject and reason",
+	}, []string{"kind", "reason"})
+
+	transitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "workflow_manager_transitions_total",
+		Help: "Service transitions, by origin and target status",
+	}, []string{"from", "to"})
+
+	failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "workflow_manager_failures_total",
+		Help: "Failed services, by error code",
+	}, []string{"error_code"})
+
+	storeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "workflow_manager_store_duration_seconds",
+		Help:    "Time spent on the storage backend, by operation",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	inFlight = newServiceSet()
+)
+
+func init() {
+	prometheus.MustRegister(messagesProcessed, messagesRejected, transitions, failures, storeDuration)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "workflow_manager_services_in_flight",
+		Help: "Services moving through their workflow",
+	}, func() float64 {
+		return float64(inFlight.len())
+	}))
+}
+
+// observeStore : records the time spent on a storage operation started at
+// the given time
+func observeStore(operation string, start time.Time) {
+	storeDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// countFailure : records a failed service for each of the given error
+// codes, or as unknown without codes
+func countFailure(codes ...string) {
+	if len(codes) == 0 {
+		codes = []string{"unknown"}
+	}
+	for _, code := range codes {
+		failures.WithLabelValues(code).Inc()
+	}
+}
+
+// serviceSet : ids of the services being tracked
+type serviceSet struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+// newServiceSet : serviceSet constructor
+func newServiceSet() *serviceSet {
+	return &serviceSet{ids: make(map[string]bool)}
+}
+
+// track : adds the service to the set while it is in progress, removing
+// it otherwise
+func (ss *serviceSet) track(s *map[string]interface{}) {
+	id, _ := (*s)["id"].(string)
+	progress := inProgress(s)
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if progress {
+		ss.ids[id] = true
+	} else {
+		delete(ss.ids, id)
+	}
+}
+
+// remove : removes the service with the given id from the set
+func (ss *serviceSet) remove(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	delete(ss.ids, id)
+}
+
+// len : gets how many services are on the set
+func (ss *serviceSet) len() int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	return len(ss.ids)
+}
+
+// messageKind : gets the kind of a received subject, so rejected messages
+// are counted on a bounded number of series whatever the subjects on the
+// platform: service messages, component results or component actions
+func messageKind(subject string) string {
+	parts := strings.Split(subject, ".")
+	switch {
+	case parts[0] == "service":
+		return "service"
+	case resultEvent(subject):
+		return "result"
+	case len(parts) == 2:
+		return "action"
+	}
+
+	return "other"
+}
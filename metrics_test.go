@@ -0,0 +1,77 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nats-io/nats"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("Given a service waiting for its components", t, func() {
+		setup()
+		defer withMemoryStore()()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "creating_components"
+		SaveService(s)
+
+		processed := testutil.ToFloat64(messagesProcessed.WithLabelValues("components.create.done"))
+		moved := testutil.ToFloat64(transitions.WithLabelValues("creating_components", "components_created"))
+		rejected := testutil.ToFloat64(messagesRejected.WithLabelValues("result", "unsupported"))
+
+		Convey("When its components are created", func() {
+			err := processInputMessage(&nats.Msg{
+				Subject: "components.create.done",
+				Data:    h.getFixture("./fixtures/components_create_done.json"),
+			})
+
+			Convey("Then the message and transitions are counted", func() {
+				So(err, ShouldBeNil)
+				So(testutil.ToFloat64(messagesProcessed.WithLabelValues("components.create.done")), ShouldEqual, processed+1)
+				So(testutil.ToFloat64(transitions.WithLabelValues("creating_components", "components_created")), ShouldEqual, moved+1)
+				So(inFlight.ids["test-generated-id"], ShouldBeTrue)
+			})
+		})
+
+		Convey("When an unsupported message is received", func() {
+			processInputMessage(&nats.Msg{
+				Subject: "unknown.create.done",
+				Data:    []byte(`{"service":"test-generated-id"}`),
+			})
+
+			Convey("Then it is counted as rejected by the kind of its subject", func() {
+				So(testutil.ToFloat64(messagesRejected.WithLabelValues("result", "unsupported")), ShouldEqual, rejected+1)
+			})
+		})
+
+		Convey("When the service is deleted", func() {
+			inFlight.track(s)
+			ServiceDel(s)
+
+			Convey("Then it is not in flight anymore", func() {
+				So(inFlight.ids["test-generated-id"], ShouldBeFalse)
+			})
+		})
+
+		Convey("When the metrics are requested", func() {
+			w := httptest.NewRecorder()
+			newServeMux().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+			body, _ := ioutil.ReadAll(w.Body)
+
+			Convey("Then they are exposed", func() {
+				So(w.Code, ShouldEqual, 200)
+				So(string(body), ShouldContainSubstring, "workflow_manager_store_duration_seconds_bucket")
+				So(string(body), ShouldContainSubstring, "workflow_manager_services_in_flight")
+			})
+		})
+	})
+}
@@ -0,0 +1,126 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats"
+	"github.com/tidwall/gjson"
+)
+
+// Reply of service-store when a conditional set finds a different revision
+// stored
+const revisionConflictReply = `{"error":"revision conflict"}`
+
+// Field of the reply service-store acknowledges a conditional set with,
+// holding the revision it checked
+const revisionCheckedField = "revision_checked"
+
+// ErrRevisionUnchecked : returned when service-store stores a mapping
+// without acknowledging the revision check, so replicas sharing it may
+// have overwritten each other
+var ErrRevisionUnchecked = errors.New("Service store didn't acknowledge the revision check")
+
+// NatsStore : persists services on the external service-store through
+// nats request / reply
+type NatsStore struct {
+	Nats    *nats.Conn
+	Timeout time.Duration
+}
+
+// NewNatsStore : NatsStore constructor
+func NewNatsStore(n *nats.Conn) *NatsStore {
+	return &NatsStore{Nats: n, Timeout: time.Second}
+}
+
+// Get : gets the mapping stored for the given key
+func (s *NatsStore) Get(key string) (string, error) {
+	msg, err := s.Nats.Request("service.get.mapping", []byte(`{"id":"`+key+`"}`), s.Timeout)
+	if err != nil {
+		return "", err
+	}
+	if string(msg.Data) == `{"error":"not found"}` {
+		return "", nil
+	}
+
+	return string(msg.Data), nil
+}
+
+// Set : stores a mapping for the given key
+func (s *NatsStore) Set(key string, value string) error {
+	body, err := json.Marshal(serviceMessage{ID: key, Mapping: value})
+	if err != nil {
+		return err
+	}
+	_, err = s.Nats.Request("service.set.mapping", body, s.Timeout)
+
+	return err
+}
+
+// Update : replaces the mapping for the given key with the one returned by
+// fn. The revision read is sent along, so service-store rejects the write
+// if another replica stored a different revision meanwhile. A write not
+// acknowledged as checked fails, instead of being taken as safe
+func (s *NatsStore) Update(key string, fn func(current string) (string, error)) error {
+	current, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	value, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	revision := storedRevision(current)
+	body, err := json.Marshal(serviceMessage{ID: key, Mapping: value, Revision: &revision})
+	if err != nil {
+		return err
+	}
+	msg, err := s.Nats.Request("service.set.mapping", body, s.Timeout)
+	if err != nil {
+		return err
+	}
+	if string(msg.Data) == revisionConflictReply {
+		return ErrRevisionConflict
+	}
+	checked := gjson.GetBytes(msg.Data, revisionCheckedField)
+	if !checked.Exists() || int(checked.Int()) != revision {
+		logger.error("service-store didn't check the revision", Fields{"service_id": key, "revision": revision})
+		return ErrRevisionUnchecked
+	}
+
+	return nil
+}
+
+// Delete : removes the given key from the service-store
+func (s *NatsStore) Delete(key string) error {
+	_, err := s.Nats.Request("service.del", []byte(`{"id":"`+key+`"}`), s.Timeout)
+
+	return err
+}
+
+// List : gets the ids of all services known by the service-store
+func (s *NatsStore) List() ([]string, error) {
+	var services []map[string]interface{}
+	var keys []string
+
+	msg, err := s.Nats.Request("service.find", []byte(`{}`), s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(msg.Data, &services); err != nil {
+		return nil, errors.New("Invalid service-store response: " + err.Error())
+	}
+	for _, srv := range services {
+		if id, ok := srv["id"].(string); ok && id != "" {
+			keys = append(keys, id)
+		}
+	}
+
+	return keys, nil
+}
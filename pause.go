@@ -0,0 +1,312 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats"
+	"github.com/tidwall/gjson"
+)
+
+// Subjects to pause and unpause a service, or all of them when the
+// message is sent with "all": true
+const (
+	pauseSubject   = "service.pause"
+	unpauseSubject = "service.unpause"
+)
+
+// Subject every instance listens on, out of the queue group, to pause or
+// unpause all services
+const globalPauseSubject = "workflow-manager.broadcast.global.pause"
+
+// Subject the releases of the events held for a service are recorded with
+const releaseSubject = "workflow-manager.release"
+
+// Key the pause of all services is persisted on, it holds no service
+const globalPauseKey = "workflow-manager#global-pause"
+
+// ErrInvalidPause : returned when a pause message is not valid json
+var ErrInvalidPause = errors.New("Invalid pause request")
+
+// pauseState : whether all services are paused on this instance
+type pauseState struct {
+	mu     sync.Mutex
+	paused bool
+}
+
+// globalPause : pause of all services, persisted on globalPauseKey so
+// instances started while it lasts are paused too
+var globalPause = &pauseState{}
+
+// set : pauses or unpauses all services
+func (ps *pauseState) set(paused bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.paused = paused
+}
+
+// get : checks if all services are paused
+func (ps *pauseState) get() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return ps.paused
+}
+
+// loadGlobalPause : reads the persisted pause of all services
+func loadGlobalPause() {
+	paused := gjson.Get(p.get(globalPauseKey), "paused").Bool()
+	globalPause.set(paused)
+	if paused {
+		logger.info("global pause", Fields{"paused": paused})
+	}
+}
+
+// Queues a service pause or unpause on the service mailbox, messages with
+// "all": true persist the pause of all services and broadcast it to every
+// instance. Messages which are not valid or have no id are replied with an
+// error, pausing nothing
+func manageServicePause(m *nats.Msg) {
+	paused := m.Subject == pauseSubject
+
+	if !gjson.ValidBytes(m.Data) {
+		replyPause(m, "", paused, ErrInvalidPause)
+		return
+	}
+	if gjson.GetBytes(m.Data, "all").Bool() {
+		replyPause(m, "", paused, setGlobalPause(paused))
+		return
+	}
+
+	mm := MessageManager{}
+	id, err := mm.getServiceID(m.Data)
+	if err != nil {
+		replyPause(m, "", paused, err)
+		return
+	}
+
+	dp.dispatch(id, func() {
+		defer inbound.receive(id, m)()
+		var err error
+		retryOnConflict(m.Subject, func() error {
+			err = processServicePause(id, paused)
+			if err == ErrRevisionConflict {
+				return err
+			}
+			return nil
+		})
+		replyPause(m, id, paused, err)
+	})
+}
+
+// setGlobalPause : persists the pause of all services and broadcasts it to
+// every instance, nothing is broadcast if it can't be persisted
+func setGlobalPause(paused bool) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"paused": paused,
+		"since":  time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	if err := p.Store.Set(globalPauseKey, string(body)); err != nil {
+		return err
+	}
+
+	return bus.Publish(globalPauseSubject, body)
+}
+
+// Pauses or unpauses all services on this instance, marking the services
+// it owns as paused, or releasing their held events on unpause
+func manageGlobalPause(m *nats.Msg) {
+	paused := gjson.GetBytes(m.Data, "paused").Bool()
+	globalPause.set(paused)
+	logger.info("global pause", Fields{"paused": paused})
+
+	keys, err := p.list()
+	if err != nil {
+		logger.error("can't list services to pause", Fields{"error": err})
+		return
+	}
+	for _, id := range keys {
+		if !sh.owns(id) {
+			continue
+		}
+		if paused {
+			manageGlobalPauseMark(id)
+		} else {
+			manageRelease(id)
+		}
+	}
+}
+
+// Queues marking a service as paused along with all services on its
+// mailbox
+func manageGlobalPauseMark(id string) {
+	dp.dispatch(id, func() {
+		defer inbound.synthetic(id, globalPauseSubject, map[string]interface{}{"id": id, "paused": true})()
+		retryOnConflict("pause of "+id, func() error {
+			return processGlobalPauseMark(id)
+		})
+	})
+}
+
+// Queues the release of the events held for a service on its mailbox
+func manageRelease(id string) {
+	dp.dispatch(id, func() {
+		defer inbound.synthetic(id, releaseSubject, map[string]interface{}{"id": id, "paused": globalPause.get()})()
+		retryOnConflict("release of "+id, func() error {
+			return processRelease(id)
+		})
+	})
+}
+
+// processServicePause : pauses a service, so its next events are held
+// once its current batch completes, or unpauses it emitting the held ones
+func processServicePause(id string, paused bool) error {
+	service := p.getService(id)
+	if service == nil {
+		return ErrServiceNotFound
+	}
+
+	if paused {
+		if pause, ok := service["paused"].(map[string]interface{}); ok && pause["global"] != true {
+			return nil
+		}
+		logger.info("paused", serviceFields(&service))
+		delete(service, "paused")
+		markPaused(&service, false)
+		return emit(&service, nil, false, pauseSubject)
+	}
+
+	if _, ok := service["paused"]; !ok {
+		return nil
+	}
+	logger.info("unpaused", serviceFields(&service))
+	delete(service, "paused")
+
+	return release(&service, unpauseSubject)
+}
+
+// processGlobalPauseMark : marks a service in progress as paused along
+// with all services, unless it is already paused
+func processGlobalPauseMark(id string) error {
+	service := p.getService(id)
+	if service == nil || !inProgress(&service) || cancelling(&service) || !globalPause.get() {
+		return nil
+	}
+	if _, ok := service["paused"]; ok {
+		return nil
+	}
+	markPaused(&service, true)
+
+	return emit(&service, nil, false, pauseSubject)
+}
+
+// processRelease : emits the events held for a service, unless it is
+// still paused
+func processRelease(id string) error {
+	service := p.getService(id)
+	if service == nil {
+		return nil
+	}
+	_, held := service["held_events"]
+	_, marked := service["paused"]
+	if !held && !marked {
+		return nil
+	}
+
+	return release(&service, unpauseSubject)
+}
+
+// release : emits the events held for a service, moving it through them,
+// unless it is still paused. A service paused along with all services is
+// unpaused once they are. The service is persisted anyway
+func release(service *map[string]interface{}, received string) error {
+	if pause, ok := (*service)["paused"].(map[string]interface{}); ok && pause["global"] == true && !globalPause.get() {
+		delete(*service, "paused")
+	}
+	if globalPause.get() {
+		markPaused(service, true)
+	}
+
+	held, _ := (*service)["held_events"].([]interface{})
+	if paused(service) || len(held) == 0 {
+		return emit(service, nil, false, received)
+	}
+
+	events := make([]string, 0, len(held))
+	for _, e := range held {
+		if event, ok := e.(string); ok {
+			events = append(events, event)
+		}
+	}
+	delete(*service, "held_events")
+	logger.info("released", serviceFields(service).with("events", events))
+
+	return emit(service, events, true, received)
+}
+
+// markPaused : marks a service as paused, along with all services if
+// global, unless it already is
+func markPaused(s *map[string]interface{}, global bool) {
+	if _, ok := (*s)["paused"]; ok {
+		return
+	}
+
+	pause := map[string]interface{}{"since": time.Now().UTC().Format(time.RFC3339Nano)}
+	if global {
+		pause["global"] = true
+	}
+	(*s)["paused"] = pause
+}
+
+// hold : keeps the next events of a paused service to be emitted once it
+// is unpaused
+func hold(service *map[string]interface{}, events []string) {
+	if globalPause.get() {
+		markPaused(service, true)
+	}
+	held, _ := (*service)["held_events"].([]interface{})
+	for _, e := range events {
+		held = append(held, e)
+	}
+	(*service)["held_events"] = held
+	logger.info("held", serviceFields(service).with("events", events))
+}
+
+// paused : checks if the events of a service have to be held, as it or
+// all services are paused
+func paused(s *map[string]interface{}) bool {
+	if _, ok := (*s)["paused"]; ok {
+		return true
+	}
+
+	return globalPause.get()
+}
+
+// Replies to a pause or unpause request
+func replyPause(m *nats.Msg, id string, paused bool, err error) {
+	if m.Reply == "" {
+		return
+	}
+
+	reply := map[string]interface{}{"paused": paused}
+	if id != "" {
+		reply["id"] = id
+		if s := p.getService(id); s != nil {
+			reply["held_events"] = s["held_events"]
+		}
+	}
+	if err != nil {
+		logger.error("can't pause service", Fields{"service_id": id, "error": err})
+		reply = map[string]interface{}{"id": id, "error": err.Error()}
+	}
+
+	body, _ := json.Marshal(reply)
+	bus.Publish(m.Reply, body)
+}
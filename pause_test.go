@@ -0,0 +1,195 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+	"github.com/tidwall/gjson"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPause(t *testing.T) {
+	Convey("Given a service waiting for its components to be created", t, func() {
+		setup()
+		r := &recorder{}
+		defer sandbox(r)()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "creating_components"
+		(*s)["pending_events"] = []interface{}{"components.create"}
+		SaveService(s)
+
+		Convey("When it is paused and the batch is done", func() {
+			err := processServicePause("test-generated-id", true)
+			So(err, ShouldBeNil)
+			processInputMessage(&nats.Msg{
+				Subject: "components.create.done",
+				Data:    h.getFixture("./fixtures/components_create_done.json"),
+			})
+			stored := p.getService("test-generated-id")
+
+			Convey("Then its next events are held", func() {
+				So(stored["status"], ShouldEqual, "components_created")
+				So(stored["held_events"], ShouldResemble, []interface{}{"components.update"})
+				So(sentSubjects(r), ShouldBeEmpty)
+			})
+
+			Convey("And it is unpaused", func() {
+				err := processServicePause("test-generated-id", false)
+				stored := p.getService("test-generated-id")
+
+				Convey("Then the held events are emitted", func() {
+					So(err, ShouldBeNil)
+					So(stored["paused"], ShouldBeNil)
+					So(stored["held_events"], ShouldBeNil)
+					So(stored["status"], ShouldEqual, "updating_components")
+					So(sentSubjects(r), ShouldResemble, []string{"components.update"})
+				})
+			})
+
+			Convey("And it is cancelled", func() {
+				err := processServiceCancel("test-generated-id", false)
+				stored := p.getService("test-generated-id")
+
+				Convey("Then the held events are dropped", func() {
+					So(err, ShouldBeNil)
+					So(stored["status"], ShouldEqual, cancelledStatus)
+					So(stored["held_events"], ShouldBeNil)
+					So(sentSubjects(r), ShouldResemble, []string{cancelDoneSubject})
+				})
+			})
+		})
+
+		Convey("When all services are paused and the batch is done", func() {
+			globalPause.set(true)
+			defer globalPause.set(false)
+			processInputMessage(&nats.Msg{
+				Subject: "components.create.done",
+				Data:    h.getFixture("./fixtures/components_create_done.json"),
+			})
+
+			Convey("Then its next events are held", func() {
+				So(p.getService("test-generated-id")["held_events"], ShouldResemble, []interface{}{"components.update"})
+				So(sentSubjects(r), ShouldBeEmpty)
+			})
+
+			Convey("Then it is marked as paused along with all services", func() {
+				paused := p.getService("test-generated-id")["paused"].(map[string]interface{})
+				So(paused["global"], ShouldEqual, true)
+			})
+
+			Convey("And they are released while still paused", func() {
+				err := processRelease("test-generated-id")
+
+				Convey("Then nothing is emitted", func() {
+					So(err, ShouldBeNil)
+					So(sentSubjects(r), ShouldBeEmpty)
+				})
+			})
+
+			Convey("And all services are unpaused", func() {
+				globalPause.set(false)
+				err := processRelease("test-generated-id")
+
+				Convey("Then the held events are emitted", func() {
+					So(err, ShouldBeNil)
+					So(p.getService("test-generated-id")["paused"], ShouldBeNil)
+					So(p.getService("test-generated-id")["status"], ShouldEqual, "updating_components")
+					So(sentSubjects(r), ShouldResemble, []string{"components.update"})
+				})
+			})
+
+			Convey("And it is paused on its own", func() {
+				processServicePause("test-generated-id", true)
+				globalPause.set(false)
+				err := processRelease("test-generated-id")
+
+				Convey("Then it stays paused when all services are unpaused", func() {
+					So(err, ShouldBeNil)
+					So(p.getService("test-generated-id")["paused"], ShouldNotBeNil)
+					So(sentSubjects(r), ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("When all services are paused through a message for all of them", func() {
+			manageServicePause(&nats.Msg{Subject: pauseSubject, Data: []byte(`{"all":true}`)})
+			defer globalPause.set(false)
+
+			Convey("Then the pause is broadcast to every instance", func() {
+				So(sentSubjects(r), ShouldResemble, []string{globalPauseSubject})
+			})
+
+			Convey("Then the pause is persisted but not listed as a service", func() {
+				keys, err := p.list()
+				So(err, ShouldBeNil)
+				So(keys, ShouldResemble, []string{"test-generated-id"})
+			})
+
+			Convey("And an instance starts", func() {
+				globalPause.set(false)
+				loadGlobalPause()
+				So(globalPause.get(), ShouldBeTrue)
+
+				Convey("Then its recovered services keep their events held", func() {
+					processInputMessage(&nats.Msg{
+						Subject: "components.create.done",
+						Data:    h.getFixture("./fixtures/components_create_done.json"),
+					})
+					So(processRelease("test-generated-id"), ShouldBeNil)
+					So(p.getService("test-generated-id")["held_events"], ShouldResemble, []interface{}{"components.update"})
+					So(sentSubjects(r), ShouldResemble, []string{globalPauseSubject})
+				})
+			})
+
+			Convey("And all services are unpaused", func() {
+				manageServicePause(&nats.Msg{Subject: unpauseSubject, Data: []byte(`{"all":true}`)})
+				globalPause.set(true)
+				loadGlobalPause()
+
+				Convey("Then the instances starting are not paused", func() {
+					So(globalPause.get(), ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("When a pause message is not valid", func() {
+			for _, body := range []string{`{"all":`, `{}`} {
+				manageServicePause(&nats.Msg{Subject: pauseSubject, Reply: "pause.reply", Data: []byte(body)})
+			}
+
+			Convey("Then nothing is paused and an error is replied", func() {
+				So(sentSubjects(r), ShouldResemble, []string{"pause.reply", "pause.reply"})
+				So(gjson.GetBytes(r.sent[0].Body, "error").String(), ShouldEqual, ErrInvalidPause.Error())
+				So(gjson.GetBytes(r.sent[1].Body, "error").Exists(), ShouldBeTrue)
+				So(p.get(globalPauseKey), ShouldBeEmpty)
+				So(globalPause.get(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When all services are paused on the instance owning it", func() {
+			manageGlobalPause(&nats.Msg{Subject: globalPauseSubject, Data: []byte(`{"paused":true}`)})
+			dp.wait(time.Second)
+			defer globalPause.set(false)
+
+			Convey("Then it is marked as paused", func() {
+				paused := p.getService("test-generated-id")["paused"].(map[string]interface{})
+				So(paused["global"], ShouldEqual, true)
+			})
+		})
+
+		Convey("When an unknown service is paused", func() {
+			err := processServicePause("unknown", true)
+
+			Convey("Then it is not found", func() {
+				So(err, ShouldEqual, ErrServiceNotFound)
+			})
+		})
+	})
+}
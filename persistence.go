@@ -6,26 +6,96 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/nats-io/nats"
+	"github.com/tidwall/gjson"
 )
 
-// Wrapper for redis in order to easily store / recover persisted
-// services
+// ErrRevisionConflict : returned when a service has been modified on the
+// store since it was loaded
+var ErrRevisionConflict = errors.New("Service revision conflict")
+
+// ErrServiceNotFound : returned when the requested service is not stored
+var ErrServiceNotFound = errors.New("Service not found")
+
+// Store : any backend able to persist service mappings. Get must return an
+// empty string and no error when the key does not exist
+type Store interface {
+	Get(key string) (string, error)
+	Set(key string, value string) error
+	Delete(key string) error
+	List() ([]string, error)
+	// Update : stores the value returned by fn for the current value of
+	// the given key, nothing is stored if fn returns an error
+	Update(key string, fn func(current string) (string, error)) error
+}
+
+// Wrapper for the configured store in order to easily store / recover
+// persisted services
 type storage struct {
-	Nats *nats.Conn
+	Nats  *nats.Conn
+	Store Store
 }
 
 type serviceMessage struct {
-	ID      string `json:"id"`
-	Mapping string `json:"mapping"`
+	ID       string `json:"id"`
+	Mapping  string `json:"mapping"`
+	Revision *int   `json:"revision,omitempty"`
 }
 
-// Prepares the connection based on a given config file
+// Prepares the connection based on the STORAGE_BACKEND environment
+// variable, defaulting to the nats service-store
 func (s *storage) load(n *nats.Conn) {
 	s.Nats = n
+
+	store, err := newStore(os.Getenv("STORAGE_BACKEND"), n)
+	if err != nil {
+		logger.error("can't load storage", Fields{"error": err})
+		panic(err)
+	}
+	if os.Getenv("PERSISTENCE_MODE") == "events" {
+		if store, err = newEventStore(store, os.Getenv("SNAPSHOT_INTERVAL")); err != nil {
+			logger.error("can't load storage", Fields{"error": err})
+			panic(err)
+		}
+	}
+	s.Store = store
+}
+
+// newEventStore : wraps the given store to persist services as events,
+// taking a snapshot every given number of events (defaults to 100)
+func newEventStore(store Store, interval string) (Store, error) {
+	every := 100
+	if interval != "" {
+		n, err := strconv.Atoi(interval)
+		if err != nil {
+			return nil, errors.New("Invalid snapshot interval " + interval)
+		}
+		every = n
+	}
+
+	return NewEventStore(store, every)
+}
+
+// newStore : builds the store for the given backend name
+func newStore(backend string, n *nats.Conn) (Store, error) {
+	switch backend {
+	case "bolt":
+		path := os.Getenv("STORAGE_PATH")
+		if path == "" {
+			path = "workflow-manager.db"
+		}
+		return NewBoltStore(path)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return NewNatsStore(n), nil
+	}
 }
 
 // Get the value for a given key
@@ -33,16 +103,16 @@ func (s *storage) get(key string) string {
 	if key == "" {
 		return ""
 	}
-	msg, err := natsClient.Request("service.get.mapping", []byte(`{"id":"`+key+`"}`), 1*time.Second)
+	defer observeStore("get", time.Now())
+	sp := tracing.start(key, "store get", spanClient)
+	value, err := s.Store.Get(key)
+	finishSpans([]*span{sp}, err)
 	if err != nil {
-		log.Println(err)
-		return ""
-	}
-	if string(msg.Data) == `{"error":"not found"}` {
+		logger.error("can't get service", Fields{"service_id": key, "error": err})
 		return ""
 	}
 
-	return string(msg.Data)
+	return value
 }
 
 // Gets a service object for a given key
@@ -63,23 +133,73 @@ func (s *storage) getService(key string) map[string]interface{} {
 
 // Set a value for a given key
 func (s *storage) set(key string, value string) error {
-	sm := serviceMessage{}
-	sm.ID = key
-	sm.Mapping = value
-	body, err := json.Marshal(sm)
-	_, err = natsClient.Request("service.set.mapping", body, 1*time.Second)
+	defer observeStore("set", time.Now())
+	sp := tracing.start(key, "store set", spanClient)
+	err := s.Store.Set(key, value)
+	finishSpans([]*span{sp}, err)
 	if err != nil {
-		log.Println(err)
-		log.Panic("Data can't be stored")
+		logger.error("data can't be stored", Fields{"service_id": key, "error": err})
+		panic("Data can't be stored")
+	}
+	return err
+}
+
+// update : atomically replaces the value for a given key
+func (s *storage) update(key string, fn func(current string) (string, error)) error {
+	defer observeStore("update", time.Now())
+	sp := tracing.start(key, "store update", spanClient)
+	err := s.Store.Update(key, fn)
+	finishSpans([]*span{sp}, err)
+	if err != nil && err != ErrRevisionConflict {
+		logger.error("data can't be stored", Fields{"service_id": key, "error": err})
+		panic("Data can't be stored")
 	}
 	return err
 }
 
 func (s *storage) del(key string) error {
-	_, err := natsClient.Request("service.del", []byte(`{"id":"`+key+`"}`), 1*time.Second)
+	defer observeStore("delete", time.Now())
+	sp := tracing.start(key, "store delete", spanClient)
+	err := s.Store.Delete(key)
+	finishSpans([]*span{sp}, err)
 	if err != nil {
-		log.Println(err)
+		logger.error("can't delete service", Fields{"service_id": key, "error": err})
 	}
 
 	return nil
 }
+
+// list : gets the keys of all persisted services, keys holding anything
+// else, as the global pause, carry a #
+func (s *storage) list() ([]string, error) {
+	defer observeStore("list", time.Now())
+	all, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, k := range all {
+		if !strings.Contains(k, eventKeySeparator) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys, nil
+}
+
+// historyStore : gets the store the history of the services is kept on,
+// as an append only log it is not persisted as events
+func (s *storage) historyStore() Store {
+	if es, ok := s.Store.(*EventStore); ok {
+		return es.store
+	}
+
+	return s.Store
+}
+
+// storedRevision : gets the revision of a persisted service, services
+// persisted without revision are considered to be on revision 0
+func storedRevision(value string) int {
+	return int(gjson.Get(value, "revision").Int())
+}
@@ -0,0 +1,184 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testStore(st Store) {
+	Convey("When I store a value", func() {
+		err := st.Set("test-generated-id", `{"id":"test-generated-id"}`)
+		So(err, ShouldBeNil)
+
+		Convey("Then I can get it back", func() {
+			value, err := st.Get("test-generated-id")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, `{"id":"test-generated-id"}`)
+		})
+
+		Convey("Then it is listed", func() {
+			keys, err := st.List()
+			So(err, ShouldBeNil)
+			So(keys, ShouldContain, "test-generated-id")
+		})
+
+		Convey("And I delete it", func() {
+			err := st.Delete("test-generated-id")
+			So(err, ShouldBeNil)
+
+			Convey("Then it is not found anymore", func() {
+				value, err := st.Get("test-generated-id")
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, "")
+			})
+		})
+	})
+
+	Convey("When I get an unexisting key", func() {
+		value, err := st.Get("unexisting")
+
+		Convey("Then I receive an empty value without errors", func() {
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "")
+		})
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	Convey("Given I have a memory store", t, func() {
+		testStore(NewMemoryStore())
+	})
+}
+
+func TestBoltStore(t *testing.T) {
+	Convey("Given I have a bolt store", t, func() {
+		dir, err := ioutil.TempDir("", "workflow-manager")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		st, err := NewBoltStore(filepath.Join(dir, "test.db"))
+		So(err, ShouldBeNil)
+		defer st.Close()
+
+		testStore(st)
+	})
+}
+
+func TestStorageBackendSelection(t *testing.T) {
+	Convey("Given I configure the memory backend", t, func() {
+		st, err := newStore("memory", nil)
+
+		Convey("Then a memory store is built", func() {
+			So(err, ShouldBeNil)
+			_, ok := st.(*MemoryStore)
+			So(ok, ShouldBeTrue)
+		})
+	})
+
+	Convey("Given I don't configure any backend", t, func() {
+		st, err := newStore("", nil)
+
+		Convey("Then the nats service-store is used", func() {
+			So(err, ShouldBeNil)
+			_, ok := st.(*NatsStore)
+			So(ok, ShouldBeTrue)
+		})
+	})
+}
+
+func TestServiceRevisions(t *testing.T) {
+	Convey("Given I have a persisted service", t, func() {
+		defer withMemoryStore()()
+
+		s, _ := h.getService("./fixtures/service.json")
+		So(SaveService(s), ShouldBeNil)
+		So((*s)["revision"], ShouldEqual, 1)
+
+		Convey("When I save a copy loaded before a newer save", func() {
+			stale := p.getService("test-generated-id")
+			fresh := p.getService("test-generated-id")
+			So(SaveService(&fresh), ShouldBeNil)
+
+			stale["status"] = "started"
+			err := SaveService(&stale)
+
+			Convey("Then I receive a revision conflict", func() {
+				So(err, ShouldEqual, ErrRevisionConflict)
+				So(stale["revision"], ShouldEqual, 1)
+				stored := p.getService("test-generated-id")
+				So(stored["revision"], ShouldEqual, 2)
+				So(stored["status"], ShouldEqual, "")
+			})
+		})
+
+		Convey("When I save the last loaded copy", func() {
+			fresh := p.getService("test-generated-id")
+			fresh["status"] = "started"
+			err := SaveService(&fresh)
+
+			Convey("Then its revision is increased", func() {
+				So(err, ShouldBeNil)
+				stored := p.getService("test-generated-id")
+				So(stored["revision"], ShouldEqual, 2)
+				So(stored["status"], ShouldEqual, "started")
+			})
+		})
+	})
+}
+
+func TestNatsStoreRevisions(t *testing.T) {
+	Convey("Given I have a service on the nats service-store", t, func() {
+		setup()
+		st := NewNatsStore(natsClient)
+		So(st.Set("nats-revision-id", `{"id":"nats-revision-id","revision":1}`), ShouldBeNil)
+
+		Convey("When another replica stores it while I update it", func() {
+			err := st.Update("nats-revision-id", func(current string) (string, error) {
+				st.Set("nats-revision-id", `{"id":"nats-revision-id","revision":2}`)
+				return `{"id":"nats-revision-id","revision":2,"status":"stale"}`, nil
+			})
+
+			Convey("Then my write is rejected with a revision conflict", func() {
+				So(err, ShouldEqual, ErrRevisionConflict)
+				value, _ := st.Get("nats-revision-id")
+				So(value, ShouldEqual, `{"id":"nats-revision-id","revision":2}`)
+			})
+		})
+
+		Convey("When I update the last stored revision", func() {
+			err := st.Update("nats-revision-id", func(current string) (string, error) {
+				return `{"id":"nats-revision-id","revision":2}`, nil
+			})
+
+			Convey("Then it is stored", func() {
+				So(err, ShouldBeNil)
+				value, _ := st.Get("nats-revision-id")
+				So(value, ShouldEqual, `{"id":"nats-revision-id","revision":2}`)
+			})
+		})
+	})
+
+	Convey("Given a service-store which doesn't support conditional sets", t, func() {
+		setup()
+		st := NewNatsStore(natsClient)
+		So(st.Set("legacy-revision-id", `{"id":"legacy-revision-id","revision":1}`), ShouldBeNil)
+
+		Convey("When I update a service", func() {
+			err := st.Update("legacy-revision-id", func(current string) (string, error) {
+				return `{"id":"legacy-revision-id","revision":2}`, nil
+			})
+
+			Convey("Then the write fails as its revision wasn't checked", func() {
+				So(err, ShouldEqual, ErrRevisionUnchecked)
+			})
+		})
+	})
+}
@@ -7,7 +7,6 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"log"
 	"strings"
 	"time"
 
@@ -27,6 +26,10 @@ type Publisher struct {
 
 // Process : starts message publication process
 func (p *Publisher) Process(s *map[string]interface{}, subject string) (result string, err error) {
+	// Cancellation is not part of the workflows
+	if subject == cancelDoneSubject {
+		return p.FinishProcessing(s, cancelledStatus), nil
+	}
 	if p.isSupportedMessage(s, subject) == false {
 		return result, errors.New("Message not supported")
 	}
@@ -53,13 +56,14 @@ func (p *Publisher) GenericHandler(s *map[string]interface{}, subject string) (s
 		Service: id,
 		Status:  "processing",
 	}
+	output.Traceparent, _ = (*s)[traceparentField].(string)
 
 	parts := strings.Split(subject, ".")
 	if len(parts) == 2 {
 		if parts[1] == "find" {
-			body, err := json.Marshal(s)
+			body, err := json.Marshal(withoutBookkeeping(s))
 			if err != nil {
-				log.Println("Can't marshal current service")
+				logger.error("can't marshal current service", serviceFields(s).with("subject", subject).with("error", err))
 			}
 			data := string(body)
 			t, _ := (*s)["type"]
@@ -74,7 +78,7 @@ func (p *Publisher) GenericHandler(s *map[string]interface{}, subject string) (s
 
 			marshalled, err := json.Marshal(output)
 			if err != nil {
-				log.Println(err)
+				logger.error("can't marshal message", serviceFields(s).with("subject", subject).with("error", err))
 				return "", errors.New(err.Error())
 			}
 
@@ -103,7 +107,7 @@ func (p *Publisher) GenericHandler(s *map[string]interface{}, subject string) (s
 
 	marshalled, err := json.Marshal(output)
 	if err != nil {
-		log.Println(err)
+		logger.error("can't marshal message", serviceFields(s).with("subject", subject).with("error", err))
 		return "", errors.New(err.Error())
 	}
 
@@ -156,9 +160,9 @@ func MapSlice(data string, values []interface{}) []interface{} {
 
 // UpdateTemplateVariables : replaces any qjson queries in fields with information from the current service build
 func (p *Publisher) UpdateTemplateVariables(items []interface{}, s *map[string]interface{}) []interface{} {
-	body, err := json.Marshal(s)
+	body, err := json.Marshal(withoutBookkeeping(s))
 	if err != nil {
-		log.Println("Can't marshal current service")
+		logger.error("can't marshal current service", serviceFields(s).with("error", err))
 		return items
 	}
 	data := string(body)
@@ -184,17 +188,18 @@ func (p *Publisher) isSupportedMessage(s *map[string]interface{}, subject string
 	return false
 }
 
-// FinishProcessing : finishes a service processation setting the final status
+// FinishProcessing : finishes a service processation setting the final
+// status, the manager bookkeeping fields are not sent
 func (p *Publisher) FinishProcessing(s *map[string]interface{}, status string) string {
 	(*s)["status"] = status
-	marshalled, err := json.Marshal(s)
+	marshalled, err := json.Marshal(withoutBookkeeping(s))
 	if err != nil {
-		log.Println(err)
+		logger.error("can't marshal current service", serviceFields(s).with("status_to", status).with("error", err))
 		return ""
 	}
 
 	id, _ := (*s)["id"].(string)
-	natsClient.Request("service.set", []byte(`{"id":"`+id+`","status":"`+status+`"}`), time.Second)
+	bus.Request("service.set", []byte(`{"id":"`+id+`","status":"`+status+`"}`), time.Second)
 
 	return string(marshalled)
 }
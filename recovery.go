@@ -0,0 +1,236 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"strings"
+)
+
+// Policies applied on startup to services waiting for the result of an
+// event emitted before the manager was stopped
+const (
+	// The pending events are sent again
+	recoveryReemit = "reemit"
+	// The services are moved through their error path
+	recoveryFail = "fail"
+	// The services are left as they are
+	recoveryNone = "none"
+)
+
+// Subjects the recovery of a service is recorded with
+const (
+	recoverySubject    = "workflow-manager.recovery"
+	interruptedSubject = "workflow-manager.interrupted"
+)
+
+// awaitsResult : checks if a component is expected to reply to the event
+// with a done or error result
+func awaitsResult(event string) bool {
+	parts := strings.Split(event, ".")
+	return len(parts) == 2 && parts[0] != "service"
+}
+
+// trackPendingEvents : records on the service the emitted events waiting
+// for a result, removing the one the received message is the result of.
+// Services reaching a terminal status wait for nothing
+func trackPendingEvents(s *map[string]interface{}, received string, events []string) {
+	var pending []interface{}
+	current, _ := (*s)["pending_events"].([]interface{})
+
+	parts := strings.Split(received, ".")
+	event := strings.Join(parts[:len(parts)-1], ".")
+	resolved := !awaitsResult(event)
+	for _, e := range current {
+		if !resolved && e == event {
+			resolved = true
+			continue
+		}
+		pending = append(pending, e)
+	}
+	for _, e := range events {
+		if awaitsResult(e) {
+			pending = append(pending, e)
+		}
+	}
+
+	w, _ := NewWorkflow(s)
+	status, _ := (*s)["status"].(string)
+	if len(pending) == 0 || w.isTerminal(status) {
+		delete(*s, "pending_events")
+		return
+	}
+	(*s)["pending_events"] = pending
+}
+
+// recoverServices : picks up the services owned by this instance which
+// were in progress when the manager was stopped, scheduling again their
+// timeouts and retries and applying the given policy to their pending
+// events
+func recoverServices(policy string) {
+	switch policy {
+	case "":
+		policy = recoveryReemit
+	case recoveryReemit, recoveryFail, recoveryNone:
+	default:
+		logger.error("unknown recovery policy", Fields{"policy": policy})
+		return
+	}
+
+	keys, err := p.list()
+	if err != nil {
+		logger.error("can't list services to recover", Fields{"error": err})
+		return
+	}
+
+	for _, id := range keys {
+		if !sh.owns(id) {
+			continue
+		}
+		service := p.getService(id)
+		if !inProgress(&service) {
+			continue
+		}
+
+		inFlight.track(&service)
+		timeouts.schedule(&service)
+		retries.schedule(&service)
+
+		// Paused services keep their events held, unless the pause of
+		// all services ended while the instance was down
+		_, held := service["held_events"]
+		_, marked := service["paused"]
+		if held || marked {
+			manageRelease(id)
+		}
+
+		pending, _ := service["pending_events"].([]interface{})
+		if len(pending) == 0 {
+			continue
+		}
+		if policy == recoveryNone {
+			manageInterrupted(id)
+			continue
+		}
+		manageRecovery(id, policy)
+	}
+}
+
+// Queues flagging a service left waiting on recovery as interrupted on
+// its mailbox
+func manageInterrupted(id string) {
+	dp.dispatch(id, func() {
+		defer inbound.synthetic(id, interruptedSubject, map[string]interface{}{"id": id})()
+		retryOnConflict("recovery of "+id, func() error {
+			return processInterrupted(id)
+		})
+	})
+}
+
+// processInterrupted : flags a service still waiting for the results of
+// its pending events as interrupted, so it can be resumed
+func processInterrupted(id string) error {
+	service := p.getService(id)
+	if !inProgress(&service) {
+		return nil
+	}
+	if pending, _ := service["pending_events"].([]interface{}); len(pending) == 0 {
+		return nil
+	}
+	service["interrupted"] = true
+
+	return SaveService(&service)
+}
+
+// inProgress : checks if a persisted service is still moving through its
+// workflow
+func inProgress(s *map[string]interface{}) bool {
+	if *s == nil {
+		return false
+	}
+	status, _ := (*s)["status"].(string)
+	if status == "" {
+		return false
+	}
+	w, err := NewWorkflow(s)
+	if err != nil || len(w.Arcs) == 0 {
+		return false
+	}
+
+	return !w.isTerminal(status)
+}
+
+// Queues the recovery of a service on its mailbox
+func manageRecovery(id string, policy string) {
+	dp.dispatch(id, func() {
+		defer inbound.synthetic(id, recoverySubject, map[string]interface{}{"id": id, "policy": policy})()
+		retryOnConflict("recovery of "+id, func() error {
+			return processRecovery(id, policy)
+		})
+	})
+}
+
+// Sends again the events a service is waiting for, or fails it, depending
+// on the recovery policy
+func processRecovery(id string, policy string) error {
+	mm := MessageManager{}
+
+	service := p.getService(id)
+	if !inProgress(&service) {
+		return nil
+	}
+	pending, _ := service["pending_events"].([]interface{})
+	if len(pending) == 0 {
+		return nil
+	}
+	status, _ := service["status"].(string)
+
+	if policy == recoveryFail {
+		event, _ := pending[0].(string)
+		logger.info("recovered as failed", serviceFields(&service).with("status_from", status).with("event", event))
+		delete(service, "pending_events")
+		service["last_known_error"] = "Interrupted waiting for " + event + " to finish on " + status
+		recordTransition(&service, status, "pre-failed", "recovery", "")
+		countFailure("interrupted")
+		service["status"] = "pre-failed"
+		return advance(&service, "to_error", "recovery")
+	}
+
+	// Batches waiting for a retry will be sent by the retry scheduler
+	r, _ := service["retry"].(map[string]interface{})
+	messages := make(map[string]string)
+	spans := make(map[string]*span)
+	parent, _ := service[traceparentField].(string)
+	for _, e := range pending {
+		event, _ := e.(string)
+		if r != nil && r["event"] == event {
+			continue
+		}
+		sp := publishSpan(&service, event, parent)
+		message, err := mm.preparePublishMessage(event, &service)
+		if err != nil {
+			logger.error("can't prepare message", serviceFields(&service).with("subject", event).with("error", err))
+			finishSpans([]*span{sp}, err)
+			continue
+		}
+		messages[event] = message
+		spans[event] = sp
+	}
+	if err := SaveService(&service); err != nil {
+		for _, sp := range spans {
+			finishSpans([]*span{sp}, err)
+		}
+		return err
+	}
+
+	for _, e := range pending {
+		event, _ := e.(string)
+		if message, ok := messages[event]; ok {
+			finishSpans([]*span{spans[event]}, bus.Publish(event, []byte(message)))
+			logger.info("recovered", serviceFields(&service).with("subject", event))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPendingEvents(t *testing.T) {
+	Convey("Given a service waiting for its components to be created", t, func() {
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "creating_components"
+		trackPendingEvents(s, "service.create", []string{"components.create"})
+
+		Convey("Then the event is pending", func() {
+			So((*s)["pending_events"], ShouldResemble, []interface{}{"components.create"})
+		})
+
+		Convey("When its result is received and the next batch is sent", func() {
+			(*s)["status"] = "updating_components"
+			trackPendingEvents(s, "components.create.done", []string{"components.update"})
+
+			Convey("Then only the new event is pending", func() {
+				So((*s)["pending_events"], ShouldResemble, []interface{}{"components.update"})
+			})
+		})
+
+		Convey("When the service is finished", func() {
+			(*s)["status"] = "done"
+			trackPendingEvents(s, "components.create.done", []string{"service.create.done"})
+
+			Convey("Then nothing is pending", func() {
+				So((*s)["pending_events"], ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestServiceRecovery(t *testing.T) {
+	Convey("Given a service interrupted while creating its components", t, func() {
+		setup()
+		defer withMemoryStore()()
+		previousSharding := sh
+		sh = sharding{Shards: 1}
+		defer func() { sh = previousSharding }()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "creating_components"
+		(*s)["pending_events"] = []interface{}{"components.create"}
+		SaveService(s)
+
+		finished, _ := h.getService("./fixtures/service_components.json")
+		(*finished)["id"] = "finished-id"
+		(*finished)["status"] = "done"
+		(*finished)["pending_events"] = []interface{}{"components.create"}
+		SaveService(finished)
+
+		received := make(chan *nats.Msg, 2)
+		sub, _ := natsClient.Subscribe("components.create", func(m *nats.Msg) {
+			received <- m
+		})
+		defer sub.Unsubscribe()
+
+		Convey("When it is recovered sending its events again", func() {
+			err := processRecovery("test-generated-id", recoveryReemit)
+
+			Convey("Then the pending event is sent", func() {
+				So(err, ShouldBeNil)
+				select {
+				case m := <-received:
+					So(string(m.Data), ShouldContainSubstring, "test-generated-id")
+				case <-time.After(time.Second):
+					So("event was not sent", ShouldBeEmpty)
+				}
+				So(p.getService("test-generated-id")["status"], ShouldEqual, "creating_components")
+			})
+		})
+
+		Convey("When it is recovered failing it", func() {
+			err := processRecovery("test-generated-id", recoveryFail)
+			stored := p.getService("test-generated-id")
+
+			Convey("Then it follows its error path", func() {
+				So(err, ShouldBeNil)
+				So(stored["status"], ShouldEqual, "errored")
+				So(stored["last_known_error"], ShouldEqual, "Interrupted waiting for components.create to finish on creating_components")
+				So(stored["pending_events"], ShouldBeNil)
+			})
+		})
+
+		Convey("When a finished service is recovered", func() {
+			err := processRecovery("finished-id", recoveryFail)
+
+			Convey("Then it is left as it is", func() {
+				So(err, ShouldBeNil)
+				So(p.getService("finished-id")["status"], ShouldEqual, "done")
+			})
+		})
+	})
+}
@@ -0,0 +1,188 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/nats-io/nats"
+	"github.com/tidwall/gjson"
+)
+
+// recordedMessage : an inbound message to be replayed, its body can be
+// the message json or a string holding it
+type recordedMessage struct {
+	Subject string          `json:"subject"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// sentMessage : a message sent while replaying
+type sentMessage struct {
+	Kind    string          `json:"kind"`
+	Subject string          `json:"subject"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// replayStep : the result of replaying a message, the service state after
+// processing it and the messages sent
+type replayStep struct {
+	Step     int                    `json:"step"`
+	Received recordedMessage        `json:"received"`
+	Error    string                 `json:"error,omitempty"`
+	Service  map[string]interface{} `json:"service"`
+	Sent     []sentMessage          `json:"sent"`
+}
+
+// recorder : a broker keeping the sent messages instead of sending them
+type recorder struct {
+	sent []sentMessage
+}
+
+// Publish : records a published message
+func (r *recorder) Publish(subject string, data []byte) error {
+	r.sent = append(r.sent, sentMessage{Kind: "publish", Subject: subject, Body: rawBody(data)})
+	return nil
+}
+
+// Request : records a request, replying with an empty message
+func (r *recorder) Request(subject string, data []byte, timeout time.Duration) (*nats.Msg, error) {
+	r.sent = append(r.sent, sentMessage{Kind: "request", Subject: subject, Body: rawBody(data)})
+	return &nats.Msg{Subject: subject}, nil
+}
+
+// replay : runs the recorded messages read from in through the workflow
+// against an in memory store, starting with the given service if any,
+// and writes each step to out
+func replay(in io.Reader, initial map[string]interface{}, out io.Writer) error {
+	r := &recorder{}
+	defer sandbox(r)()
+
+	if initial != nil {
+		delete(initial, "revision")
+		if err := SaveService(&initial); err != nil {
+			return err
+		}
+	}
+
+	dec := json.NewDecoder(in)
+	for i := 1; ; i++ {
+		var m recordedMessage
+		if err := dec.Decode(&m); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.New("Invalid recorded message : " + err.Error())
+		}
+		m.Body = messageBody(m.Body)
+
+		r.sent = nil
+		step := replayStep{Step: i, Received: m}
+		id, err := replayMessage(&nats.Msg{Subject: m.Subject, Data: m.Body})
+		if err != nil {
+			step.Error = err.Error()
+		}
+		step.Service = p.getService(id)
+		step.Sent = r.sent
+
+		body, err := json.MarshalIndent(step, "", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(append(body, '\n')); err != nil {
+			return err
+		}
+	}
+}
+
+// sandbox : runs the workflow offline, against an in memory store and
+// sending messages to the given recorder, until the returned function
+// restores the daemon setup. Timeouts and retries are not armed, as the
+// ones which fired are recorded as messages, replayed as received ones
+func sandbox(r *recorder) func() {
+	store, broker, ts, rs := p.Store, bus, timeouts, retries
+	paused := globalPause.get()
+
+	p.Store = NewMemoryStore()
+	bus = r
+	timeouts = newTimeoutScheduler("timeout", func(string, string) {})
+	retries = newTimeoutScheduler("retry", func(string, string) {})
+
+	return func() {
+		p.Store, bus, timeouts, retries = store, broker, ts, rs
+		globalPause.set(paused)
+	}
+}
+
+// replayMessage : processes a recorded message as the daemon would,
+// returning the id of its service
+func replayMessage(m *nats.Msg) (string, error) {
+	mm := MessageManager{}
+	id, err := mm.getServiceID(m.Data)
+	if err != nil {
+		return "", err
+	}
+
+	switch m.Subject {
+	case timeoutSubject:
+		return id, processTimeout(id, gjson.GetBytes(m.Data, "status").String())
+	case scheduledRetrySubject:
+		return id, processRetry(id, gjson.GetBytes(m.Data, "status").String())
+	case recoverySubject:
+		return id, processRecovery(id, gjson.GetBytes(m.Data, "policy").String())
+	case interruptedSubject:
+		return id, processInterrupted(id)
+	case globalPauseSubject:
+		globalPause.set(true)
+		return id, processGlobalPauseMark(id)
+	case releaseSubject:
+		globalPause.set(gjson.GetBytes(m.Data, "paused").Bool())
+		return id, processRelease(id)
+	case adminTransitionSubject:
+		return id, processForcedTransition(id, gjson.GetBytes(m.Data, "event").String())
+	case resumeSubject:
+		_, err = processServiceResume(id)
+		return id, err
+	case historySubject:
+		return id, nil
+	case cancelSubject:
+		return id, processServiceCancel(id, gjson.GetBytes(m.Data, "compensate").Bool())
+	case pauseSubject, unpauseSubject:
+		return id, processServicePause(id, m.Subject == pauseSubject)
+	}
+
+	if err := processInputMessage(m); err != nil {
+		return id, err
+	}
+	if m.Subject == "service.delete.done" {
+		s := p.getService(id)
+		ServiceDel(&s)
+	}
+
+	return id, nil
+}
+
+// messageBody : gets the json of a recorded body, which can be stored as
+// a string
+func messageBody(body json.RawMessage) json.RawMessage {
+	var s string
+	if err := json.Unmarshal(body, &s); err == nil {
+		return json.RawMessage(s)
+	}
+
+	return body
+}
+
+// rawBody : keeps a sent body as json when it is, or as a string
+// otherwise
+func rawBody(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	body, _ := json.Marshal(string(data))
+
+	return body
+}
@@ -0,0 +1,101 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReplay(t *testing.T) {
+	Convey("Given a recorded service build", t, func() {
+		var in bytes.Buffer
+		enc := json.NewEncoder(&in)
+		enc.Encode(map[string]interface{}{
+			"subject": "service.create",
+			"body":    json.RawMessage(h.getServiceBody("./fixtures/service_components.json")),
+		})
+		enc.Encode(map[string]interface{}{
+			"subject": "components.create.done",
+			"body":    string(h.getFixture("./fixtures/components_create_done.json")),
+		})
+		store, broker := p.Store, bus
+
+		Convey("When it is replayed", func() {
+			var out bytes.Buffer
+			initial, _ := h.getService("./fixtures/service_components.json")
+			err := replay(&in, *initial, &out)
+
+			var steps []replayStep
+			dec := json.NewDecoder(&out)
+			for dec.More() {
+				var step replayStep
+				So(dec.Decode(&step), ShouldBeNil)
+				steps = append(steps, step)
+			}
+
+			Convey("Then every step is printed", func() {
+				So(err, ShouldBeNil)
+				So(len(steps), ShouldEqual, 2)
+				So(steps[0].Service["status"], ShouldEqual, "creating_components")
+				So(steps[1].Service["status"], ShouldEqual, "updating_components")
+				So(steps[1].Error, ShouldBeEmpty)
+			})
+
+			Convey("Then the sent messages are recorded", func() {
+				var subjects []string
+				for _, m := range steps[0].Sent {
+					subjects = append(subjects, m.Kind+" "+m.Subject)
+				}
+				So(subjects, ShouldContain, "request service.set")
+				So(subjects, ShouldContain, "publish components.create")
+				So(steps[1].Sent[len(steps[1].Sent)-1].Subject, ShouldEqual, "components.update")
+			})
+
+			Convey("Then nothing is kept once finished", func() {
+				So(p.Store, ShouldEqual, store)
+				So(bus, ShouldEqual, broker)
+			})
+		})
+	})
+
+	Convey("Given a recorded build which timed out", t, func() {
+		var in bytes.Buffer
+		json.NewEncoder(&in).Encode(map[string]interface{}{
+			"subject": timeoutSubject,
+			"body":    map[string]interface{}{"id": "test-generated-id", "status": "creating_components"},
+		})
+		initial, _ := h.getService("./fixtures/service_components.json")
+		(*initial)["status"] = "creating_components"
+		(*initial)["timeout"] = map[string]interface{}{"status": "creating_components", "event": "components.create"}
+
+		Convey("When it is replayed", func() {
+			var out bytes.Buffer
+			err := replay(&in, *initial, &out)
+			var step replayStep
+			So(json.Unmarshal(out.Bytes(), &step), ShouldBeNil)
+
+			Convey("Then the service times out as it did", func() {
+				So(err, ShouldBeNil)
+				So(step.Error, ShouldBeEmpty)
+				So(step.Service["status"], ShouldEqual, "errored")
+				So(step.Service["last_known_error"], ShouldEqual, "Timed out waiting for components.create to finish on creating_components")
+			})
+		})
+	})
+
+	Convey("Given an invalid recording", t, func() {
+		var out bytes.Buffer
+		err := replay(strings.NewReader(`{"subject":`), nil, &out)
+
+		Convey("Then it fails", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
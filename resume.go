@@ -0,0 +1,219 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+// Subject used to drive again a failed or interrupted service
+const resumeSubject = "service.resume"
+
+// ErrNotResumable : returned when resuming a service which is neither
+// failed nor interrupted, as the events it waits for are still in flight
+var ErrNotResumable = errors.New("Service is neither failed nor interrupted")
+
+// resumePoint : where a service workflow has to be restarted, the status
+// the service has to be on, the branch statuses if it is a fork and the
+// events to be sent again
+type resumePoint struct {
+	status   string
+	branches map[string]interface{}
+	events   []string
+}
+
+// Queues a service resume on the service mailbox
+func manageServiceResume(m *nats.Msg) {
+	mm := MessageManager{}
+	id, err := mm.getServiceID(m.Data)
+	if err != nil {
+		replyResume(m, "", nil, err)
+		return
+	}
+
+	dp.dispatch(id, func() {
+		defer inbound.receive(id, m)()
+		var events []string
+		var err error
+		retryOnConflict(m.Subject, func() error {
+			events, err = processServiceResume(id)
+			if err == ErrRevisionConflict {
+				return err
+			}
+			return nil
+		})
+		replyResume(m, id, events, err)
+	})
+}
+
+// Restarts the workflow of a persisted service at its first step with
+// pending components, returning the events sent again
+func processServiceResume(id string) ([]string, error) {
+	service := p.getService(id)
+	if service == nil {
+		return nil, ErrServiceNotFound
+	}
+	if cancelling(&service) {
+		return nil, errors.New("Service is being cancelled")
+	}
+	if status, _ := service["status"].(string); status != "errored" && status != "failed" && service["interrupted"] != true {
+		return nil, ErrNotResumable
+	}
+	if _, ok := service["compensation"]; ok {
+		return nil, errors.New("Service components were compensated, it has to be created again")
+	}
+
+	w, err := NewWorkflow(&service)
+	if err != nil {
+		return nil, err
+	}
+	r, err := w.resumePoint(&service)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("[RESUMED]", id, r.status)
+	status, _ := service["status"].(string)
+	recordTransition(&service, status, r.status, resumeSubject, "")
+	service["status"] = r.status
+	if r.branches != nil {
+		service["branches"] = r.branches
+	} else {
+		delete(service, "branches")
+	}
+	for _, field := range []string{"timeout", "timed_out", "retry", "pending_events", "cancel", "interrupted"} {
+		delete(service, field)
+	}
+	for _, event := range r.events {
+		if batch, ok := service[batchKey(event)].(map[string]interface{}); ok {
+			delete(batch, "attempts")
+		}
+	}
+
+	if err := emit(&service, r.events, true, resumeSubject); err != nil {
+		return nil, err
+	}
+	bus.Request("service.set", []byte(`{"id":"`+id+`","status":"in_progress"}`), time.Second)
+
+	return r.events, nil
+}
+
+// Replies to a resume request with its result
+func replyResume(m *nats.Msg, id string, events []string, err error) {
+	if m.Reply == "" {
+		return
+	}
+
+	reply := map[string]interface{}{"id": id, "status": "in_progress", "events": events}
+	if err != nil {
+		log.Println("[ERROR] : can't resume " + id + " : " + err.Error())
+		reply = map[string]interface{}{"id": id, "status": "errored", "error": err.Error()}
+	}
+
+	body, _ := json.Marshal(reply)
+	bus.Publish(m.Reply, body)
+}
+
+// resumePoint : walks the workflow from the arc the service was created
+// with, skipping the steps already done, until the first one with pending
+// components or the final service event
+func (w *Workflow) resumePoint(s *map[string]interface{}) (*resumePoint, error) {
+	data := serviceData(s)
+	visited := make(map[string]bool)
+
+	status := "created"
+	entry, err := w.nextEvent(status, data)
+	if err != nil {
+		return nil, err
+	}
+	a, _ := w.nextArc(status, entry, data)
+	status = a.To
+
+	for !visited[status] {
+		visited[status] = true
+
+		if branches := w.branches(status); len(branches) > 0 {
+			r, join := w.resumeBranches(s, status, branches, data)
+			if r != nil {
+				return r, nil
+			}
+			status = join
+			continue
+		}
+
+		event, err := w.nextEvent(status, data)
+		if err != nil {
+			break
+		}
+		if pendingStep(s, event) {
+			return &resumePoint{status: status, events: []string{event}}, nil
+		}
+		a, _ := w.nextArc(status, event, data)
+		status = a.To
+	}
+
+	return nil, errors.New("Service has no pending steps")
+}
+
+// resumeBranches : gets the pending event of each branch starting on a
+// fork, or the join status if all of them are done
+func (w *Workflow) resumeBranches(s *map[string]interface{}, fork string, branches []string, data string) (*resumePoint, string) {
+	r := &resumePoint{status: fork, branches: make(map[string]interface{})}
+	var join string
+
+	for _, b := range branches {
+		status := fork
+		visited := make(map[string]bool)
+		for !visited[status] && !(status != fork && w.branchDone(b, status)) {
+			visited[status] = true
+			event, err := w.nextBranchEvent(b, status, data)
+			if err != nil {
+				break
+			}
+			if pendingStep(s, event) {
+				r.events = append(r.events, event)
+				break
+			}
+			a, _ := w.nextBranchArc(b, status, event, data)
+			status = a.To
+		}
+		r.branches[b] = status
+		join = status
+	}
+
+	if len(r.events) == 0 {
+		return nil, join
+	}
+
+	return r, ""
+}
+
+// pendingStep : checks if the given event still has to be sent, service
+// events always have to, batches only if they have components not
+// processed yet and results are considered as received
+func pendingStep(s *map[string]interface{}, event string) bool {
+	parts := strings.Split(event, ".")
+	if parts[0] == "service" {
+		return true
+	}
+	if len(parts) != 2 {
+		return false
+	}
+	if parts[1] == "find" {
+		found, _ := (*s)[parts[0]].(map[string]interface{})
+		return found["status"] != "completed"
+	}
+
+	batch, _ := (*s)[batchKey(event)].(map[string]interface{})
+	items, _ := batch["items"].([]interface{})
+
+	return len(items) > 0 && batch["status"] != "completed"
+}
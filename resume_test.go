@@ -0,0 +1,144 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResumePoint(t *testing.T) {
+	Convey("Given an errored service whose components were created", t, func() {
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "errored"
+		(*s)["components_to_create"].(map[string]interface{})["status"] = "completed"
+		(*s)["components_to_create"].(map[string]interface{})["items"] = []interface{}{}
+		(*s)["components_to_update"].(map[string]interface{})["status"] = "errored"
+		w, _ := NewWorkflow(s)
+
+		Convey("When I get where it has to be resumed", func() {
+			r, err := w.resumePoint(s)
+
+			Convey("Then it is the first batch with pending components", func() {
+				So(err, ShouldBeNil)
+				So(r.status, ShouldEqual, "components_created")
+				So(r.events, ShouldResemble, []string{"components.update"})
+				So(r.branches, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given an errored service with all its components processed", t, func() {
+		s, _ := h.getService("./fixtures/service_components.json")
+		for _, batch := range []string{"components_to_create", "components_to_update", "components_to_delete"} {
+			(*s)[batch].(map[string]interface{})["items"] = []interface{}{}
+		}
+		w, _ := NewWorkflow(s)
+
+		Convey("When I get where it has to be resumed", func() {
+			r, err := w.resumePoint(s)
+
+			Convey("Then it is the final service event", func() {
+				So(err, ShouldBeNil)
+				So(r.status, ShouldEqual, "components_deleted")
+				So(r.events, ShouldResemble, []string{"service.create.done"})
+			})
+		})
+	})
+
+	Convey("Given a forked service with a pending branch", t, func() {
+		s, _ := h.getService("./fixtures/service_fork.json")
+		(*s)["status"] = "errored"
+		(*s)["networks_to_create"] = map[string]interface{}{"status": "completed", "items": []interface{}{}}
+		(*s)["firewalls_to_create"] = map[string]interface{}{"status": "completed", "items": []interface{}{}}
+		(*s)["firewalls_to_update"] = map[string]interface{}{"status": "errored", "items": []interface{}{
+			map[string]interface{}{"name": "web"},
+		}}
+		w, _ := NewWorkflow(s)
+
+		Convey("When I get where it has to be resumed", func() {
+			r, err := w.resumePoint(s)
+
+			Convey("Then only the pending branch is sent again", func() {
+				So(err, ShouldBeNil)
+				So(r.status, ShouldEqual, "started")
+				So(r.events, ShouldResemble, []string{"firewalls.update"})
+				So(r.branches["networks"], ShouldEqual, "components_ready")
+				So(r.branches["firewalls"], ShouldEqual, "firewalls_created")
+			})
+		})
+	})
+}
+
+func TestServiceResume(t *testing.T) {
+	Convey("Given a persisted service which failed updating its components", t, func() {
+		setup()
+		defer withMemoryStore()()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "errored"
+		(*s)["components_to_create"].(map[string]interface{})["status"] = "completed"
+		(*s)["components_to_create"].(map[string]interface{})["items"] = []interface{}{}
+		(*s)["components_to_update"].(map[string]interface{})["status"] = "errored"
+		(*s)["components_to_update"].(map[string]interface{})["attempts"] = 3
+		SaveService(s)
+
+		Convey("When it is resumed", func() {
+			events, err := processServiceResume("test-generated-id")
+			stored := p.getService("test-generated-id")
+
+			Convey("Then the failed batch is sent again", func() {
+				So(err, ShouldBeNil)
+				So(events, ShouldResemble, []string{"components.update"})
+				So(stored["status"], ShouldEqual, "updating_components")
+				So(stored["components_to_update"].(map[string]interface{})["attempts"], ShouldBeNil)
+			})
+		})
+
+		Convey("When it was compensated", func() {
+			(*s)["compensation"] = map[string]interface{}{"status": "completed"}
+			SaveService(s)
+			_, err := processServiceResume("test-generated-id")
+
+			Convey("Then it can't be resumed", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When it is still waiting for its components", func() {
+			(*s)["status"] = "creating_components"
+			(*s)["pending_events"] = []interface{}{"components.create"}
+			SaveService(s)
+			_, err := processServiceResume("test-generated-id")
+			stored := p.getService("test-generated-id")
+
+			Convey("Then it can't be resumed as its batch is in flight", func() {
+				So(err, ShouldEqual, ErrNotResumable)
+				So(stored["pending_events"], ShouldResemble, []interface{}{"components.create"})
+			})
+
+			Convey("And it was interrupted", func() {
+				So(processInterrupted("test-generated-id"), ShouldBeNil)
+				events, err := processServiceResume("test-generated-id")
+				stored := p.getService("test-generated-id")
+
+				Convey("Then it is resumed", func() {
+					So(err, ShouldBeNil)
+					So(events, ShouldResemble, []string{"components.update"})
+					So(stored["interrupted"], ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("When it does not exist", func() {
+			_, err := processServiceResume("unknown")
+
+			Convey("Then it can't be resumed", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
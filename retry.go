@@ -0,0 +1,150 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Subject returned for error messages whose batch will be sent again
+const retrySubject = "to_retry"
+
+// Subject the retries sent for a service are recorded with
+const scheduledRetrySubject = "workflow-manager.retry"
+
+// RetryPolicy : defines how a failed batch is sent again before failing
+// the service. It can be defined on the batch itself or on the arc
+// sending it
+type RetryPolicy struct {
+	// Total times the batch will be sent, including the first one
+	MaxAttempts int `json:"max_attempts"`
+	// Time to wait before sending the batch again, doubled on each attempt
+	Backoff string `json:"backoff"`
+	// Only errors with one of these codes are retried, any if empty
+	ErrorCodes []string `json:"error_codes"`
+}
+
+// validate : checks the policy values
+func (r *RetryPolicy) validate() error {
+	if r.MaxAttempts < 1 {
+		return errors.New("max_attempts must be greater than 0")
+	}
+	if _, err := r.backoff(1); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// backoff : gets the time to wait before the given attempt
+func (r *RetryPolicy) backoff(attempt int) (time.Duration, error) {
+	if r.Backoff == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(r.Backoff)
+	if err != nil || d < 0 {
+		return 0, errors.New("invalid backoff " + r.Backoff)
+	}
+	for i := 2; i < attempt; i++ {
+		d = d * 2
+	}
+
+	return d, nil
+}
+
+// allows : checks if any of the given error codes can be retried
+func (r *RetryPolicy) allows(codes []string) bool {
+	if len(r.ErrorCodes) == 0 {
+		return true
+	}
+	for _, code := range codes {
+		for _, allowed := range r.ErrorCodes {
+			if code == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// batchRetryPolicy : gets the retry policy for a batch, the one defined on
+// the batch takes precedence over the one defined on the workflow
+func batchRetryPolicy(s *map[string]interface{}, event string) *RetryPolicy {
+	batch, _ := (*s)[batchKey(event)].(map[string]interface{})
+	if raw, ok := batch["retry"]; ok {
+		var policy RetryPolicy
+		body, _ := json.Marshal(raw)
+		if err := json.Unmarshal(body, &policy); err != nil {
+			logger.error("invalid retry policy", serviceFields(s).with("subject", event).with("error", err))
+			return nil
+		}
+		return &policy
+	}
+
+	w, _ := NewWorkflow(s)
+
+	return w.retryPolicy(event)
+}
+
+// Persists a service whose failed batch will be sent again
+func scheduleRetry(s *map[string]interface{}) error {
+	if err := SaveService(s); err != nil {
+		return err
+	}
+	retries.schedule(s)
+
+	return nil
+}
+
+// Queues a batch retry on the service mailbox
+func manageRetry(id string, status string) {
+	dp.dispatch(id, func() {
+		defer inbound.synthetic(id, scheduledRetrySubject, map[string]interface{}{"id": id, "status": status})()
+		retryOnConflict("retry of "+id, func() error {
+			return processRetry(id, status)
+		})
+	})
+}
+
+// Sends again the failed components of a batch, if the service is still
+// waiting for it
+func processRetry(id string, status string) error {
+	mm := MessageManager{}
+
+	service := p.getService(id)
+	if service == nil {
+		return nil
+	}
+
+	r, _ := service["retry"].(map[string]interface{})
+	current, _ := service["status"].(string)
+	if r == nil || r["status"] != status || current != status {
+		return nil
+	}
+	event, _ := r["event"].(string)
+	delete(service, "retry")
+
+	parent, _ := service[traceparentField].(string)
+	sp := publishSpan(&service, event, parent)
+	message, err := mm.preparePublishMessage(event, &service)
+	if err != nil {
+		logger.error("can't prepare message", serviceFields(&service).with("subject", event).with("error", err))
+		finishSpans([]*span{sp}, err)
+		return SaveService(&service)
+	}
+	if err := SaveService(&service); err != nil {
+		finishSpans([]*span{sp}, err)
+		return err
+	}
+
+	finishSpans([]*span{sp}, bus.Publish(event, []byte(message)))
+	logger.info("retried", serviceFields(&service).with("subject", event))
+
+	return nil
+}
@@ -0,0 +1,119 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+	"github.com/tidwall/gjson"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	Convey("Given I have a retry policy", t, func() {
+		policy := RetryPolicy{MaxAttempts: 3, Backoff: "10s", ErrorCodes: []string{"429"}}
+
+		Convey("Then the backoff is doubled on each attempt", func() {
+			d, _ := policy.backoff(2)
+			So(d, ShouldEqual, 10*time.Second)
+			d, _ = policy.backoff(3)
+			So(d, ShouldEqual, 20*time.Second)
+		})
+
+		Convey("Then only allowed error codes are retried", func() {
+			So(policy.allows([]string{"500", "429"}), ShouldBeTrue)
+			So(policy.allows([]string{"500"}), ShouldBeFalse)
+			So(policy.allows(nil), ShouldBeFalse)
+		})
+
+		Convey("Then invalid policies are detected", func() {
+			So(policy.validate(), ShouldBeNil)
+			So((&RetryPolicy{MaxAttempts: 0}).validate(), ShouldNotBeNil)
+			So((&RetryPolicy{MaxAttempts: 2, Backoff: "soon"}).validate(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFailedBatchRetries(t *testing.T) {
+	Convey("Given a service creating components with a retry policy", t, func() {
+		setup()
+		defer withMemoryStore()()
+
+		body := h.getFixture("./fixtures/components_create_error.json")
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "creating_components"
+		(*s)["components_to_create"].(map[string]interface{})["retry"] = map[string]interface{}{
+			"max_attempts": 2,
+			"error_codes":  []interface{}{"429"},
+		}
+		SaveService(s)
+
+		Convey("When the batch fails with a retryable error", func() {
+			mm := MessageManager{}
+			s, subject, err := mm.getServiceFromMessage("components.create.error", body)
+			b, _ := json.Marshal(s)
+			sBody := string(b)
+
+			Convey("Then the failed components will be sent again", func() {
+				So(err, ShouldBeNil)
+				So(subject, ShouldEqual, retrySubject)
+				So(s["status"], ShouldEqual, "creating_components")
+				So(gjson.Get(sBody, "components_to_create.attempts").Int(), ShouldEqual, 2)
+				So(gjson.Get(sBody, "components_to_create.items.#").Int(), ShouldEqual, 1)
+				So(gjson.Get(sBody, "components_to_create.items.0.name").String(), ShouldEqual, "updated")
+				So(gjson.Get(sBody, "components_to_create.items.0.status").Exists(), ShouldBeFalse)
+				So(gjson.Get(sBody, "components.items.#(name==\"added\")").Exists(), ShouldBeTrue)
+				So(gjson.Get(sBody, "retry.event").String(), ShouldEqual, "components.create")
+				So(gjson.Get(sBody, "retry.status").String(), ShouldEqual, "creating_components")
+			})
+
+			Convey("And the retry is processed", func() {
+				received := make(chan *nats.Msg, 1)
+				sub, _ := natsClient.Subscribe("components.create", func(m *nats.Msg) {
+					received <- m
+				})
+				defer sub.Unsubscribe()
+
+				So(scheduleRetry(&s), ShouldBeNil)
+				retries.stop()
+				So(processRetry("test-generated-id", "creating_components"), ShouldBeNil)
+
+				Convey("Then only the failed components are sent", func() {
+					select {
+					case m := <-received:
+						So(gjson.GetBytes(m.Data, "components.#").Int(), ShouldEqual, 1)
+						So(gjson.GetBytes(m.Data, "components.0.name").String(), ShouldEqual, "updated")
+					case <-time.After(time.Second):
+						So("batch was not sent again", ShouldBeEmpty)
+					}
+					So(p.getService("test-generated-id")["retry"], ShouldBeNil)
+				})
+
+				Convey("And the batch fails again", func() {
+					s, subject, _ := mm.getServiceFromMessage("components.create.error", body)
+
+					Convey("Then the service is failed", func() {
+						So(subject, ShouldEqual, "to_error")
+						So(s["status"], ShouldEqual, "pre-failed")
+					})
+				})
+			})
+		})
+
+		Convey("When the batch fails with an error not allowed to be retried", func() {
+			mm := MessageManager{}
+			s, subject, _ := mm.getServiceFromMessage("components.create.error", []byte(`{"service":"test-generated-id","error_code":"500","components":[]}`))
+
+			Convey("Then the service is failed", func() {
+				So(subject, ShouldEqual, "to_error")
+				So(s["status"], ShouldEqual, "pre-failed")
+			})
+		})
+	})
+}
@@ -0,0 +1,84 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Servers of the http endpoints and the admin api, shut down before the
+// subscriptions are drained
+var (
+	httpServer  = &http.Server{Handler: newServeMux()}
+	adminServer = &http.Server{Handler: newAdminMux()}
+)
+
+// newServeMux : routes the http endpoints exposed by the manager
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", healthHandler(liveness))
+	mux.Handle("/readyz", healthHandler(readiness))
+
+	return mux
+}
+
+// newAdminMux : routes the admin api, kept apart from the public
+// endpoints as it exposes the service documents
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", adminHandler)
+	mux.HandleFunc("/services/", adminHandler)
+
+	return mux
+}
+
+// serveHTTP : exposes the http endpoints on the address defined by the
+// HTTP_ADDR environment variable, defaults to :8080
+func serveHTTP() {
+	addr := os.Getenv("HTTP_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	httpServer.Addr = addr
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.error("http server stopped", Fields{"addr": addr, "error": err})
+	}
+}
+
+// serveAdmin : exposes the admin api on the address defined by the
+// ADMIN_ADDR environment variable, defaults to 127.0.0.1:8081 so it is
+// only reachable from the host
+func serveAdmin() {
+	addr := os.Getenv("ADMIN_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:8081"
+	}
+
+	adminServer.Addr = addr
+	if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.error("admin server stopped", Fields{"addr": addr, "error": err})
+	}
+}
+
+// stopServers : stops accepting http requests, waiting until the given
+// deadline for the ones being served, so no admin change is queued once
+// the services in flight are drained
+func stopServers(deadline time.Time) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	for _, s := range []*http.Server{adminServer, httpServer} {
+		if err := s.Shutdown(ctx); err != nil {
+			logger.error("can't stop http server", Fields{"addr": s.Addr, "error": err})
+		}
+	}
+}
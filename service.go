@@ -32,17 +32,65 @@ type service struct {
 	} `json:"options"`
 }
 
-// SaveService : persists the service
+// Fields the manager keeps on a service to track its build, not sent to
+// components nor evaluated by arc conditions
+var bookkeepingFields = []string{"history", "pending_events", "held_events", "timeout", "retry", "revision", "interrupted", traceparentField}
+
+// withoutBookkeeping : gets a shallow copy of the service without the
+// manager bookkeeping fields
+func withoutBookkeeping(s *map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(*s))
+	for k, v := range *s {
+		c[k] = v
+	}
+	for _, field := range bookkeepingFields {
+		delete(c, field)
+	}
+
+	return c
+}
+
+// SaveService : persists the service increasing its revision. If the
+// service was loaded with a revision and the stored one is different
+// ErrRevisionConflict is returned and nothing is stored
 func SaveService(s *map[string]interface{}) error {
-	json, err := json.Marshal(s)
+	id, _ := (*s)["id"].(string)
+	revision, versioned := (*s)["revision"].(float64)
+
+	// The recorded transitions are moved to the history log once the
+	// service is stored
+	transitions, _ := (*s)["history"].([]interface{})
+	delete(*s, "history")
+	defer inbound.transit(id, transitions)()
+
+	err := p.update(id, func(current string) (string, error) {
+		stored := storedRevision(current)
+		if versioned && int(revision) != stored {
+			return "", ErrRevisionConflict
+		}
+		(*s)["revision"] = float64(stored + 1)
+
+		body, err := json.Marshal(s)
+		if err != nil {
+			return "", err
+		}
+
+		return string(body), nil
+	})
 	if err != nil {
+		if versioned {
+			(*s)["revision"] = revision
+		} else {
+			delete(*s, "revision")
+		}
+		if transitions != nil {
+			(*s)["history"] = transitions
+		}
 		log.Println(err)
 		return err
 	}
-	id, _ := (*s)["id"].(string)
-	err = p.set(id, string(json))
-	if err != nil {
-		log.Println(err)
+	if err := appendHistory(id, transitions); err != nil {
+		logger.error("can't store history", serviceFields(s).with("error", err).with("transitions", transitions))
 		return err
 	}
 
@@ -53,12 +101,15 @@ func SaveService(s *map[string]interface{}) error {
 func ServiceDel(s *map[string]interface{}) {
 	id, _ := (*s)["id"].(string)
 	p.del(id)
+	inFlight.remove(id)
 }
 
-// TransferCreated : transferst the components_to_created to components array
+// TransferCreated : transferst the components_to_created to components array,
+// recording the created ones as completed on the batch
 func TransferCreated(s *map[string]interface{}, cType string, input GenericComponentMsg) {
 	var components []interface{}
 	var erroredComponents []interface{}
+	var createdComponents []interface{}
 
 	inputComponents := input.Components
 	currentComponents := (*s)[cType].(map[string]interface{})
@@ -74,6 +125,7 @@ func TransferCreated(s *map[string]interface{}, cType string, input GenericCompo
 			erroredComponents = append(erroredComponents, c)
 		} else {
 			components = append(components, c)
+			createdComponents = append(createdComponents, c)
 		}
 	}
 	currentComponents["status"] = "completed"
@@ -81,6 +133,8 @@ func TransferCreated(s *map[string]interface{}, cType string, input GenericCompo
 
 	// Remove to be created components
 	if componentsToBeProcessed, ok := (*s)[cType+"_to_create"].(map[string]interface{}); ok {
+		completed, _ := componentsToBeProcessed["completed"].([]interface{})
+		componentsToBeProcessed["completed"] = append(completed, createdComponents...)
 		componentsToBeProcessed["items"] = erroredComponents
 		componentsToBeProcessed["status"] = input.Status
 		componentsToBeProcessed["error_code"] = input.ErrorCode
@@ -0,0 +1,114 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/nats-io/nats"
+)
+
+// sharding : splits services between several workflow-manager instances.
+// Every instance subscribes to the platform messages on the same queue
+// group, so each message is received by only one of them, and forwards
+// messages for services it does not own to the instance owning its
+// shard. All instances must be configured with the same number of shards
+// and each shard must be run by a single instance
+type sharding struct {
+	Shards int
+	Shard  int
+	Group  string
+}
+
+// forwardedMessage : envelope for messages forwarded to another shard
+type forwardedMessage struct {
+	Subject string `json:"subject"`
+	Reply   string `json:"reply"`
+	Data    []byte `json:"data"`
+}
+
+// loadSharding : gets the sharding configuration from the WORKFLOW_SHARDS,
+// WORKFLOW_SHARD and WORKFLOW_QUEUE_GROUP environment variables, by
+// default there is a single shard
+func loadSharding() sharding {
+	sh := sharding{Shards: 1, Group: os.Getenv("WORKFLOW_QUEUE_GROUP")}
+
+	if v := os.Getenv("WORKFLOW_SHARDS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Panic("Invalid WORKFLOW_SHARDS value : " + v)
+		}
+		sh.Shards = n
+	}
+	if v := os.Getenv("WORKFLOW_SHARD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n >= sh.Shards {
+			log.Panic("Invalid WORKFLOW_SHARD value : " + v)
+		}
+		sh.Shard = n
+	}
+	if sh.Group == "" {
+		sh.Group = "workflow-manager"
+	}
+
+	return sh
+}
+
+// owner : gets the shard owning the given service id
+func (sh *sharding) owner(id string) int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+
+	return jumpHash(h.Sum64(), sh.Shards)
+}
+
+// owns : checks if the given service id belongs to this instance shard
+func (sh *sharding) owns(id string) bool {
+	return sh.owner(id) == sh.Shard
+}
+
+// subject : gets the subject where messages for a shard are forwarded,
+// it has four tokens so it never matches the platform subscriptions
+func (sh *sharding) subject(shard int) string {
+	return "workflow-manager.shard." + strconv.Itoa(shard) + ".messages"
+}
+
+// forward : sends a message to the shard owning the given service id
+func (sh *sharding) forward(id string, m *nats.Msg) error {
+	body, err := json.Marshal(forwardedMessage{Subject: m.Subject, Reply: m.Reply, Data: m.Data})
+	if err != nil {
+		return err
+	}
+
+	return bus.Publish(sh.subject(sh.owner(id)), body)
+}
+
+// unwrap : gets the original message from a forwarded one
+func (sh *sharding) unwrap(m *nats.Msg) (*nats.Msg, error) {
+	var f forwardedMessage
+	if err := json.Unmarshal(m.Data, &f); err != nil {
+		return nil, err
+	}
+
+	return &nats.Msg{Subject: f.Subject, Reply: f.Reply, Data: f.Data}, nil
+}
+
+// jumpHash : jump consistent hash by Lamping and Veach, when the number of
+// shards changes only the minimum amount of services change their owner
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShardOwnership(t *testing.T) {
+	Convey("Given I have four shards", t, func() {
+		sh := sharding{Shards: 4}
+
+		Convey("When I get the owner of several services", func() {
+			counts := make(map[int]int)
+			for i := 0; i < 1000; i++ {
+				counts[sh.owner("service-"+strconv.Itoa(i))]++
+			}
+
+			Convey("Then all shards own some of them", func() {
+				So(len(counts), ShouldEqual, 4)
+				for shard, n := range counts {
+					So(shard, ShouldBeBetweenOrEqual, 0, 3)
+					So(n, ShouldBeGreaterThan, 150)
+				}
+			})
+
+			Convey("Then the owner is always the same", func() {
+				So(sh.owner("service-1"), ShouldEqual, sh.owner("service-1"))
+			})
+		})
+
+		Convey("When a fifth shard is added", func() {
+			grown := sharding{Shards: 5}
+			moved := 0
+			for i := 0; i < 1000; i++ {
+				id := "service-" + strconv.Itoa(i)
+				if sh.owner(id) != grown.owner(id) {
+					So(grown.owner(id), ShouldEqual, 4)
+					moved++
+				}
+			}
+
+			Convey("Then only services moved to the new shard change their owner", func() {
+				So(moved, ShouldBeLessThan, 300)
+			})
+		})
+	})
+
+	Convey("Given I have a single shard", t, func() {
+		sh := sharding{Shards: 1}
+
+		Convey("Then it owns every service", func() {
+			So(sh.owns("service-1"), ShouldBeTrue)
+			So(sh.owns("service-2"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestForwardedMessages(t *testing.T) {
+	Convey("Given I have a forwarded message", t, func() {
+		setup()
+
+		sh := sharding{Shards: 2, Shard: 0}
+		id := "service-1"
+		for i := 0; sh.owns(id); i++ {
+			id = "service-" + strconv.Itoa(i)
+		}
+
+		received := make(chan *nats.Msg, 1)
+		sub, _ := natsClient.Subscribe(sh.subject(1), func(m *nats.Msg) {
+			received <- m
+		})
+		defer sub.Unsubscribe()
+
+		err := sh.forward(id, &nats.Msg{Subject: "instances.create.done", Reply: "inbox", Data: []byte(`{"service":"` + id + `"}`)})
+		So(err, ShouldBeNil)
+
+		Convey("When the owner shard unwraps it", func() {
+			m, err := sh.unwrap(<-received)
+
+			Convey("Then it gets the original message", func() {
+				So(err, ShouldBeNil)
+				So(m.Subject, ShouldEqual, "instances.create.done")
+				So(m.Reply, ShouldEqual, "inbox")
+				So(string(m.Data), ShouldEqual, `{"service":"`+id+`"}`)
+			})
+		})
+	})
+}
@@ -0,0 +1,111 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+// waitForShutdown : blocks until the process is asked to stop, shutting
+// it down gracefully
+func waitForShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-signals
+	logger.info("shutdown", Fields{"signal": sig.String()})
+
+	shutdown(shutdownTimeout())
+}
+
+// shutdownTimeout : gets the time in flight messages have to be processed
+// on shutdown from SHUTDOWN_TIMEOUT, defaults to 30s
+func shutdownTimeout() time.Duration {
+	value := os.Getenv("SHUTDOWN_TIMEOUT")
+	if value == "" {
+		return 30 * time.Second
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.warn("invalid shutdown timeout, using 30s", Fields{"timeout": value})
+		return 30 * time.Second
+	}
+
+	return d
+}
+
+// shutdown : stops serving http requests and receiving messages, waits
+// for the ones in flight to be processed and releases the connections.
+// Scheduled timeouts and retries are persisted, so they are picked up by
+// the next instance, and not armed again meanwhile. If messages
+// are still being processed when the timeout is reached the connections
+// are kept, so they don't fail storing the services, and the interrupted
+// services are recovered by the next instance
+func shutdown(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	stopServers(deadline)
+	timeouts.close()
+	retries.close()
+
+	if remaining := drain(subscriptions, deadline); remaining > 0 {
+		logger.error("shutdown timed out draining subscriptions", Fields{"subscriptions": remaining})
+	}
+	if pending := dp.wait(time.Until(deadline)); pending > 0 {
+		logger.error("shutdown timed out processing services", Fields{"services": pending})
+		tracing.stop()
+		return
+	}
+
+	if err := natsClient.Flush(); err != nil {
+		logger.error("can't flush nats messages", Fields{"error": err})
+	}
+	if err := closeStore(p.Store); err != nil {
+		logger.error("can't close the storage", Fields{"error": err})
+	}
+	natsClient.Close()
+	tracing.stop()
+
+	logger.info("shutdown done", Fields{})
+}
+
+// drain : stops receiving messages on the given subscriptions, handling
+// the ones already delivered, and waits until they are drained or the
+// deadline is reached. Returns how many are still draining
+func drain(subs []*nats.Subscription, deadline time.Time) int {
+	for _, s := range subs {
+		if err := s.Drain(); err != nil {
+			logger.error("can't drain subscription", Fields{"subject": s.Subject, "error": err})
+		}
+	}
+
+	for {
+		draining := 0
+		for _, s := range subs {
+			if s.IsValid() {
+				draining++
+			}
+		}
+		if draining == 0 || time.Now().After(deadline) {
+			return draining
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// closeStore : closes the storage backend if it holds any resource
+func closeStore(store Store) error {
+	if c, ok := store.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShutdown(t *testing.T) {
+	Convey("Given a shutdown timeout is configured", t, func() {
+		os.Setenv("SHUTDOWN_TIMEOUT", "5s")
+		defer os.Unsetenv("SHUTDOWN_TIMEOUT")
+
+		Convey("Then it is used", func() {
+			So(shutdownTimeout(), ShouldEqual, 5*time.Second)
+		})
+	})
+
+	Convey("Given an invalid shutdown timeout", t, func() {
+		os.Setenv("SHUTDOWN_TIMEOUT", "soon")
+		defer os.Unsetenv("SHUTDOWN_TIMEOUT")
+
+		Convey("Then the default one is used", func() {
+			So(shutdownTimeout(), ShouldEqual, 30*time.Second)
+		})
+	})
+
+	Convey("Given a subscription with delivered messages", t, func() {
+		setup()
+		handled := make(chan bool, 10)
+		s, _ := natsClient.Subscribe("shutdown.drain", func(m *nats.Msg) {
+			time.Sleep(10 * time.Millisecond)
+			handled <- true
+		})
+		for i := 0; i < 5; i++ {
+			natsClient.Publish("shutdown.drain", []byte(`{}`))
+		}
+		natsClient.Flush()
+
+		Convey("When it is drained", func() {
+			remaining := drain([]*nats.Subscription{s}, time.Now().Add(5*time.Second))
+
+			Convey("Then all delivered messages are handled", func() {
+				So(remaining, ShouldEqual, 0)
+				So(len(handled), ShouldEqual, 5)
+				So(s.IsValid(), ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given a closed timeout scheduler", t, func() {
+		ts := newTimeoutScheduler("timeout", func(id string, status string) {})
+		ts.close()
+
+		Convey("When a service still being processed schedules a deadline", func() {
+			ts.schedule(&map[string]interface{}{
+				"id": "test-generated-id",
+				"timeout": map[string]interface{}{
+					"status":   "creating_components",
+					"deadline": time.Now().Add(time.Minute).Format(time.RFC3339Nano),
+				},
+			})
+
+			Convey("Then no timer is armed", func() {
+				So(ts.timers, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given the http servers are stopped", t, func() {
+		previousHTTP, previousAdmin := httpServer, adminServer
+		httpServer = &http.Server{Addr: "127.0.0.1:0", Handler: newServeMux()}
+		adminServer = &http.Server{Addr: "127.0.0.1:0", Handler: newAdminMux()}
+		defer func() { httpServer, adminServer = previousHTTP, previousAdmin }()
+		stopServers(time.Now().Add(time.Second))
+
+		Convey("Then no admin request is served anymore", func() {
+			So(adminServer.ListenAndServe(), ShouldEqual, http.ErrServerClosed)
+		})
+	})
+
+	Convey("Given services are persisted as events on bolt", t, func() {
+		dir, _ := ioutil.TempDir("", "workflow-manager")
+		defer os.RemoveAll(dir)
+		bolt, err := NewBoltStore(filepath.Join(dir, "test.db"))
+		So(err, ShouldBeNil)
+		st, _ := NewEventStore(bolt, 10)
+
+		Convey("When the storage is closed", func() {
+			err := closeStore(st)
+
+			Convey("Then the bolt file is released", func() {
+				So(err, ShouldBeNil)
+				So(bolt.Set("test", "{}"), ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given services are kept in memory", t, func() {
+		Convey("Then there is nothing to close", func() {
+			So(closeStore(NewMemoryStore()), ShouldBeNil)
+		})
+	})
+}
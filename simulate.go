@@ -0,0 +1,121 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats"
+)
+
+// Messages a simulation processes before giving up on a workflow that
+// never ends
+const maxSimulationSteps = 1000
+
+// simulationStep : a message answered during a simulation and the status
+// the service moved to
+type simulationStep struct {
+	Step     int    `json:"step"`
+	Received string `json:"received"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// simulate : drives a service definition through its workflow offline,
+// answering every event sent to the components as done, or as errored for
+// the given failing events, and writes each step to out. Returns the final
+// service
+func simulate(definition map[string]interface{}, failing []string, out io.Writer) (map[string]interface{}, error) {
+	r := &recorder{}
+	defer sandbox(r)()
+
+	id, _ := definition["id"].(string)
+	if id == "" {
+		return nil, errors.New("The service definition has no id")
+	}
+	delete(definition, "revision")
+	if err := SaveService(&definition); err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(definition)
+	if err != nil {
+		return nil, err
+	}
+	queue := []sentMessage{{Subject: "service.create", Body: body}}
+
+	for i := 1; len(queue) > 0; i++ {
+		if i > maxSimulationSteps {
+			return nil, errors.New("The workflow didn't finish after " + strconv.Itoa(maxSimulationSteps) + " steps")
+		}
+		m := queue[0]
+		queue = queue[1:]
+
+		r.sent = nil
+		step := simulationStep{Step: i, Received: m.Subject}
+		if _, err := replayMessage(&nats.Msg{Subject: m.Subject, Data: m.Body}); err != nil {
+			step.Error = err.Error()
+		}
+		s := p.getService(id)
+		step.Status, _ = s["status"].(string)
+
+		line, err := json.Marshal(step)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := out.Write(append(line, '\n')); err != nil {
+			return nil, err
+		}
+
+		for _, sent := range r.sent {
+			if reply, ok := simulatedResult(sent, failing); ok {
+				queue = append(queue, reply)
+			}
+		}
+	}
+
+	return p.getService(id), nil
+}
+
+// simulatedResult : gets the result a component would send for an event
+// published by the manager, service events are final and have no result
+func simulatedResult(m sentMessage, failing []string) (sentMessage, bool) {
+	parts := strings.Split(m.Subject, ".")
+	if m.Kind != "publish" || len(parts) != 2 || parts[0] == "service" {
+		return sentMessage{}, false
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(m.Body, &body); err != nil {
+		return sentMessage{}, false
+	}
+
+	result, status := "done", "completed"
+	for _, f := range failing {
+		if f == m.Subject {
+			result, status = "error", "errored"
+			body["error_code"] = "simulated"
+			body["error"] = "Simulated failure of " + m.Subject
+		}
+	}
+	body["status"] = status
+	components, _ := body["components"].([]interface{})
+	for _, c := range components {
+		if component, ok := c.(map[string]interface{}); ok {
+			component["status"] = status
+		}
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return sentMessage{}, false
+	}
+
+	return sentMessage{Kind: "publish", Subject: m.Subject + "." + result, Body: data}, true
+}
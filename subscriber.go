@@ -6,7 +6,6 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 	"strings"
 	"time"
 )
@@ -44,7 +43,7 @@ func (sub *Subscriber) Process(s *map[string]interface{}, subject string, body [
 	default:
 		parts := strings.Split(subject, ".")
 		if len(parts) != 3 || parts[0] == "service" {
-			log.Println("Message not supported : " + subject)
+			logger.warn("message not supported", serviceFields(s).with("subject", subject))
 			return false, ""
 		}
 		input := NewGenericComponentMsg(body)
@@ -58,7 +57,7 @@ func (sub *Subscriber) Process(s *map[string]interface{}, subject string, body [
 		case "find":
 			TransferFound(s, parts[0], input)
 		default:
-			log.Println("Message not supported")
+			logger.warn("message not supported", serviceFields(s).with("subject", subject))
 			return false, ""
 		}
 	}
@@ -90,12 +89,13 @@ func (sub *Subscriber) isSupportedMessage(s *map[string]interface{}, subject str
 func (sub *Subscriber) ServiceCreate(s *map[string]interface{}, subject string, body []byte) *map[string]interface{} {
 
 	if err := json.Unmarshal(body, &s); err != nil {
-		log.Println(err)
+		logger.error("invalid service", Fields{"subject": subject, "error": err})
 		return nil
 	}
+	setCorrelationID(s, body)
 
 	id, _ := (*s)["id"].(string)
-	natsClient.Request("service.set", []byte(`{"id":"`+id+`","status":"in_progress"}`), time.Second)
+	bus.Request("service.set", []byte(`{"id":"`+id+`","status":"in_progress"}`), time.Second)
 
 	return s
 }
@@ -103,13 +103,18 @@ func (sub *Subscriber) ServiceCreate(s *map[string]interface{}, subject string,
 // ServiceDelete : Entry point to the flow environment deletion, it will trigger
 // a cleanup of the entire service
 func (sub *Subscriber) ServiceDelete(s *map[string]interface{}, subject string, body []byte) *map[string]interface{} {
+	status, _ := (*s)["status"].(string)
 	if err := json.Unmarshal(body, &s); err != nil {
-		log.Println(err)
+		logger.error("invalid service", Fields{"subject": subject, "error": err})
 		return nil
 	}
+	setCorrelationID(s, body)
 	id, _ := (*s)["id"].(string)
+	if status != "" {
+		recordTransition(s, status, "created", subject, "")
+	}
 	(*s)["status"] = "created"
-	natsClient.Request("service.set", []byte(`{"id":"`+id+`","status":"in_progress"}`), time.Second)
+	bus.Request("service.set", []byte(`{"id":"`+id+`","status":"in_progress"}`), time.Second)
 
 	return s
 }
@@ -117,10 +122,15 @@ func (sub *Subscriber) ServiceDelete(s *map[string]interface{}, subject string,
 // ServicePatch Entry point to the flow environment patching, it will create the service and attach
 // a default workflow to it
 func (sub *Subscriber) ServicePatch(s *map[string]interface{}, subject string, body []byte) *map[string]interface{} {
+	status, _ := (*s)["status"].(string)
 	if err := json.Unmarshal(body, &s); err != nil {
-		log.Println(err)
+		logger.error("invalid service", Fields{"subject": subject, "error": err})
 		return nil
 	}
+	setCorrelationID(s, body)
+	if status != "" {
+		recordTransition(s, status, "created", subject, "")
+	}
 	(*s)["status"] = ""
 
 	return s
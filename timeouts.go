@@ -0,0 +1,133 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Subject the timeouts of a service are recorded with
+const timeoutSubject = "workflow-manager.timeout"
+
+// timeoutScheduler : keeps a timer for each service with a deadline on
+// the given field, firing when the deadline is reached. The field holds
+// the status the service has to be on and the deadline. Once closed no
+// timer is armed anymore
+type timeoutScheduler struct {
+	mu     sync.Mutex
+	field  string
+	timers map[string]*time.Timer
+	fire   func(id string, status string)
+	closed bool
+}
+
+// newTimeoutScheduler : timeoutScheduler constructor
+func newTimeoutScheduler(field string, fire func(id string, status string)) *timeoutScheduler {
+	return &timeoutScheduler{
+		field:  field,
+		timers: make(map[string]*time.Timer),
+		fire:   fire,
+	}
+}
+
+// schedule : arms the timer for the service deadline, or cancels its
+// timer if it has no deadline anymore
+func (ts *timeoutScheduler) schedule(s *map[string]interface{}) {
+	id, _ := (*s)["id"].(string)
+	t, _ := (*s)[ts.field].(map[string]interface{})
+	status, _ := t["status"].(string)
+	value, _ := t["deadline"].(string)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if timer, ok := ts.timers[id]; ok {
+		timer.Stop()
+		delete(ts.timers, id)
+	}
+	if t == nil || ts.closed {
+		return
+	}
+
+	deadline, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		logger.error("invalid deadline", Fields{"service_id": id, ts.field: value})
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(deadline.Sub(time.Now()), func() {
+		ts.mu.Lock()
+		if ts.timers[id] == timer {
+			delete(ts.timers, id)
+		}
+		ts.mu.Unlock()
+
+		ts.fire(id, status)
+	})
+	ts.timers[id] = timer
+}
+
+// stop : cancels all timers
+func (ts *timeoutScheduler) stop() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for id, timer := range ts.timers {
+		timer.Stop()
+		delete(ts.timers, id)
+	}
+}
+
+// close : cancels all timers and stops arming new ones, so the services
+// still being processed on shutdown don't schedule them again
+func (ts *timeoutScheduler) close() {
+	ts.stop()
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.closed = true
+}
+
+// Queues a timeout on the service mailbox
+func manageTimeout(id string, status string) {
+	dp.dispatch(id, func() {
+		defer inbound.synthetic(id, timeoutSubject, map[string]interface{}{"id": id, "status": status})()
+		retryOnConflict("timeout of "+id, func() error {
+			return processTimeout(id, status)
+		})
+	})
+}
+
+// Fails a service which is still on the status it timed out, recording
+// on which event it was waiting
+func processTimeout(id string, status string) error {
+	service := p.getService(id)
+	if service == nil {
+		return nil
+	}
+
+	t, _ := service["timeout"].(map[string]interface{})
+	current, _ := service["status"].(string)
+	if t == nil || t["status"] != status || current != status {
+		return nil
+	}
+	event, _ := t["event"].(string)
+
+	logger.warn("timed out", serviceFields(&service).with("status_from", status).with("event", event))
+	delete(service, "timeout")
+	service["timed_out"] = map[string]interface{}{
+		"status": status,
+		"event":  event,
+	}
+	service["last_known_error"] = "Timed out waiting for " + event + " to finish on " + status
+	recordTransition(&service, status, "pre-failed", "timeout", "")
+	countFailure("timeout")
+	service["status"] = "pre-failed"
+
+	return advance(&service, "to_error", "timeout")
+}
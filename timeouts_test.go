@@ -0,0 +1,131 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func withTimeout(s *map[string]interface{}, event string, timeout string) {
+	for _, a := range (*s)["workflow"].(map[string]interface{})["arcs"].([]interface{}) {
+		arc := a.(map[string]interface{})
+		if arc["event"] == event {
+			arc["timeout"] = timeout
+		}
+	}
+}
+
+func TestStatusTimeouts(t *testing.T) {
+	Convey("Given a service with a timeout waiting for its components", t, func() {
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "started"
+		withTimeout(s, "components.create", "1h")
+
+		Convey("When the service moves to the status with a timeout", func() {
+			err := em.move(s, "components.create")
+
+			Convey("Then its deadline is set", func() {
+				So(err, ShouldBeNil)
+				timeout := (*s)["timeout"].(map[string]interface{})
+				So(timeout["status"], ShouldEqual, "creating_components")
+				So(timeout["event"], ShouldEqual, "components.create")
+				deadline, err := time.Parse(time.RFC3339Nano, timeout["deadline"].(string))
+				So(err, ShouldBeNil)
+				So(deadline, ShouldHappenWithin, time.Minute, time.Now().Add(time.Hour))
+			})
+
+			Convey("And it leaves the status", func() {
+				err := em.move(s, "components.create.done")
+
+				Convey("Then its deadline is removed", func() {
+					So(err, ShouldBeNil)
+					So((*s)["timeout"], ShouldBeNil)
+				})
+			})
+		})
+	})
+
+	Convey("Given I have a timeout scheduler", t, func() {
+		fired := make(chan string, 1)
+		ts := newTimeoutScheduler("timeout", func(id string, status string) {
+			fired <- id + ":" + status
+		})
+		defer ts.stop()
+
+		s := map[string]interface{}{
+			"id": "test-generated-id",
+			"timeout": map[string]interface{}{
+				"status":   "creating_components",
+				"deadline": time.Now().Add(10 * time.Millisecond).Format(time.RFC3339Nano),
+			},
+		}
+
+		Convey("When a service deadline is reached", func() {
+			ts.schedule(&s)
+
+			Convey("Then the timeout is fired", func() {
+				select {
+				case v := <-fired:
+					So(v, ShouldEqual, "test-generated-id:creating_components")
+				case <-time.After(time.Second):
+					So("timeout was not fired", ShouldBeEmpty)
+				}
+			})
+		})
+
+		Convey("When a service leaves the status before its deadline", func() {
+			ts.schedule(&s)
+			delete(s, "timeout")
+			ts.schedule(&s)
+
+			Convey("Then the timeout is not fired", func() {
+				select {
+				case v := <-fired:
+					So(v, ShouldBeEmpty)
+				case <-time.After(50 * time.Millisecond):
+				}
+			})
+		})
+	})
+}
+
+func TestTimeoutProcessing(t *testing.T) {
+	Convey("Given a service which timed out waiting for its components", t, func() {
+		setup()
+		defer withMemoryStore()()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "started"
+		withTimeout(s, "components.create", "1ms")
+		em.move(s, "components.create")
+		SaveService(s)
+
+		Convey("When the timeout is processed", func() {
+			err := processTimeout("test-generated-id", "creating_components")
+			stored := p.getService("test-generated-id")
+
+			Convey("Then the service is failed", func() {
+				So(err, ShouldBeNil)
+				So(stored["status"], ShouldEqual, "errored")
+				So(stored["last_known_error"], ShouldEqual, "Timed out waiting for components.create to finish on creating_components")
+				So(stored["timed_out"].(map[string]interface{})["event"], ShouldEqual, "components.create")
+				So(stored["timeout"], ShouldBeNil)
+			})
+		})
+
+		Convey("When the service already left the status", func() {
+			err := processTimeout("test-generated-id", "started")
+			stored := p.getService("test-generated-id")
+
+			Convey("Then the service is not modified", func() {
+				So(err, ShouldBeNil)
+				So(stored["status"], ShouldEqual, "creating_components")
+			})
+		})
+	})
+}
@@ -0,0 +1,290 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Field carrying the trace context on messages, following the w3c trace
+// context traceparent format
+const traceparentField = "traceparent"
+
+// Span kinds
+const (
+	spanInternal = trace.SpanKindInternal
+	spanClient   = trace.SpanKindClient
+	spanProducer = trace.SpanKindProducer
+	spanConsumer = trace.SpanKindConsumer
+)
+
+// Propagates the trace context on the traceparent field
+var propagator = propagation.TraceContext{}
+
+// traceCarrier : propagation.TextMapCarrier over the traceparent field of
+// a message or a service
+type traceCarrier struct {
+	traceparent string
+}
+
+// Get : gets the value of the given propagation key
+func (c *traceCarrier) Get(key string) string {
+	if key == traceparentField {
+		return c.traceparent
+	}
+	return ""
+}
+
+// Set : sets the value of the given propagation key
+func (c *traceCarrier) Set(key string, value string) {
+	if key == traceparentField {
+		c.traceparent = value
+	}
+}
+
+// Keys : lists the propagation keys the carrier holds
+func (c *traceCarrier) Keys() []string {
+	return []string{traceparentField}
+}
+
+// span : an operation of a trace, active for its service until it is
+// finished
+type span struct {
+	tracer *tracer
+	key    string
+	ctx    context.Context
+	span   trace.Span
+}
+
+// tracer : creates spans, keeping the active ones for each service so
+// nested operations are children of the one processing the service. As
+// the messages of a service are processed one at a time the active spans
+// are kept by service instead of threading a context
+type tracer struct {
+	mu       sync.Mutex
+	active   map[string][]*span
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// tracing : the manager tracer, configured with TRACING_EXPORTER
+var tracing = newTracer(nil)
+
+// newTracer : tracer constructor, spans are only exported if an exporter
+// is given
+func newTracer(exporter sdktrace.SpanExporter) *tracer {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "workflow-manager"),
+			attribute.String("service.instance.id", instanceID),
+		)),
+	}
+	if exporter != nil {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+
+	return &tracer{
+		active:   make(map[string][]*span),
+		provider: provider,
+		tracer:   provider.Tracer("workflow-manager"),
+	}
+}
+
+// loadTracer : builds the tracer for the TRACING_EXPORTER environment
+// variable, otlp sends the spans to OTEL_EXPORTER_OTLP_ENDPOINT and file
+// writes them on TRACING_FILE
+func loadTracer() (*tracer, error) {
+	switch os.Getenv("TRACING_EXPORTER") {
+	case "otlp":
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return newTracer(exporter), nil
+	case "file":
+		path := os.Getenv("TRACING_FILE")
+		if path == "" {
+			path = "traces.json"
+		}
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			return nil, err
+		}
+		return newTracer(exporter), nil
+	case "", "none":
+		return newTracer(nil), nil
+	}
+
+	return nil, errors.New("Unknown tracing exporter " + os.Getenv("TRACING_EXPORTER"))
+}
+
+// start : starts a span for the given service, child of its active span
+func (t *tracer) start(key string, name string, kind trace.SpanKind) *span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.push(key, name, kind, t.current(key))
+}
+
+// startRemote : starts a span for the given service, child of the given
+// trace context, or on a new trace if it is not valid
+func (t *tracer) startRemote(key string, name string, kind trace.SpanKind, traceparent string) *span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.push(key, name, kind, remoteContext(traceparent))
+}
+
+// startLeaf : starts a span for the given service, child of its active
+// span or of the given trace context if there is none. The span doesn't
+// become active, so it won't be the parent of later operations
+func (t *tracer) startLeaf(key string, name string, kind trace.SpanKind, traceparent string) *span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parent := t.current(key)
+	if len(t.active[key]) == 0 {
+		parent = remoteContext(traceparent)
+	}
+
+	return t.create(key, name, kind, parent)
+}
+
+// current : gets the context of the active span for the service
+func (t *tracer) current(key string) context.Context {
+	if stack := t.active[key]; len(stack) > 0 {
+		return stack[len(stack)-1].ctx
+	}
+
+	return context.Background()
+}
+
+// push : creates a span and makes it the active one for the service
+func (t *tracer) push(key string, name string, kind trace.SpanKind, parent context.Context) *span {
+	s := t.create(key, name, kind, parent)
+	if key != "" {
+		t.active[key] = append(t.active[key], s)
+	}
+
+	return s
+}
+
+// create : creates a span, on a new trace if its parent has none
+func (t *tracer) create(key string, name string, kind trace.SpanKind, parent context.Context) *span {
+	options := []trace.SpanStartOption{trace.WithSpanKind(kind)}
+	if key != "" {
+		options = append(options, trace.WithAttributes(attribute.String("service.id", key)))
+	}
+	ctx, sp := t.tracer.Start(parent, name, options...)
+
+	return &span{tracer: t, key: key, ctx: ctx, span: sp}
+}
+
+// remoteContext : gets a context holding the given trace context, empty
+// if it is not valid
+func remoteContext(traceparent string) context.Context {
+	return propagator.Extract(context.Background(), &traceCarrier{traceparent: traceparent})
+}
+
+// publishSpan : starts the span publishing an event for the service and
+// injects its trace context on the service, so the emitted message
+// carries it. Without an active span the span is a child of the given
+// trace context, usually the one the service was last emitted on
+func publishSpan(s *map[string]interface{}, event string, parent string) *span {
+	id, _ := (*s)["id"].(string)
+
+	sp := tracing.startLeaf(id, "publish "+event, spanProducer, parent)
+	sp.set("messaging.destination", event)
+	(*s)[traceparentField] = sp.traceparent()
+
+	return sp
+}
+
+// finishSpans : finishes the given spans, failed with the given error if
+// any
+func finishSpans(spans []*span, err error) {
+	for _, s := range spans {
+		if err != nil {
+			s.fail(err)
+		}
+		s.finish()
+	}
+}
+
+// set : adds an attribute to the span
+func (s *span) set(key string, value string) *span {
+	s.span.SetAttributes(attribute.String(key, value))
+	return s
+}
+
+// fail : records the error the span operation failed with
+func (s *span) fail(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// traceparent : gets the trace context of the span to be propagated
+func (s *span) traceparent() string {
+	c := &traceCarrier{}
+	propagator.Inject(s.ctx, c)
+
+	return c.traceparent
+}
+
+// finish : ends the span, which stops being active
+func (s *span) finish() {
+	t := s.tracer
+	s.span.End()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stack := t.active[s.key]
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == s {
+			stack = append(stack[:i], stack[i+1:]...)
+			break
+		}
+	}
+	if len(stack) == 0 {
+		delete(t.active, s.key)
+	} else {
+		t.active[s.key] = stack
+	}
+}
+
+// stop : exports the pending spans
+func (t *tracer) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := t.provider.Shutdown(ctx); err != nil {
+		logger.error("can't export spans", Fields{"error": err})
+	}
+}
+
+// messageTraceparent : gets the trace context carried by a message body
+func messageTraceparent(body []byte) string {
+	return gjson.GetBytes(body, traceparentField).String()
+}
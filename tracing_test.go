@@ -0,0 +1,170 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/nats-io/nats"
+	"github.com/tidwall/gjson"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type collector struct {
+	mu    sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (c *collector) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, spans...)
+	return nil
+}
+
+func (c *collector) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (c *collector) children(name string, parent sdktrace.ReadOnlySpan) []sdktrace.ReadOnlySpan {
+	var found []sdktrace.ReadOnlySpan
+	for _, s := range c.named(name) {
+		if s.Parent().SpanID() == parent.SpanContext().SpanID() {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+func (c *collector) named(name string) []sdktrace.ReadOnlySpan {
+	var found []sdktrace.ReadOnlySpan
+	for _, s := range c.spans {
+		if s.Name() == name {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+func TestTraceparent(t *testing.T) {
+	Convey("Given a valid traceparent", t, func() {
+		sc := trace.SpanContextFromContext(remoteContext("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+
+		Convey("Then its trace and parent span are read", func() {
+			So(sc.IsValid(), ShouldBeTrue)
+			So(sc.TraceID().String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(sc.SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+			So(sc.IsSampled(), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given invalid traceparents", t, func() {
+		for _, v := range []string{
+			"",
+			"4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		} {
+			sc := trace.SpanContextFromContext(remoteContext(v))
+
+			Convey("Then "+v+" is rejected", func() {
+				So(sc.IsValid(), ShouldBeFalse)
+			})
+		}
+	})
+}
+
+func TestTracing(t *testing.T) {
+	Convey("Given a traced service waiting for its components", t, func() {
+		setup()
+		c := &collector{}
+		r := &recorder{}
+		defer sandbox(r)()
+		previousTracer := tracing
+		tracing = newTracer(c)
+		defer func() { tracing = previousTracer }()
+
+		s, _ := h.getService("./fixtures/service_components.json")
+		(*s)["status"] = "creating_components"
+		SaveService(s)
+
+		var body map[string]interface{}
+		json.Unmarshal(h.getFixture("./fixtures/components_create_done.json"), &body)
+		body["traceparent"] = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		data, _ := json.Marshal(body)
+
+		Convey("When its components are created", func() {
+			err := processInputMessage(&nats.Msg{Subject: "components.create.done", Data: data})
+			tracing.stop()
+
+			Convey("Then the processing continues the inbound trace", func() {
+				So(err, ShouldBeNil)
+				process := c.named("process components.create.done")
+				So(len(process), ShouldEqual, 1)
+				So(process[0].SpanContext().TraceID().String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+				So(process[0].Parent().SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+				So(process[0].SpanKind(), ShouldEqual, spanConsumer)
+
+				for _, name := range []string{"subscriber", "fsm.transition", "store update"} {
+					So(len(c.children(name, process[0])), ShouldBeGreaterThan, 0)
+				}
+				subscriber := c.children("subscriber", process[0])[0]
+				So(len(c.children("store get", subscriber)), ShouldEqual, 1)
+			})
+
+			Convey("Then the emitted messages carry the publish span context", func() {
+				So(len(r.sent), ShouldBeGreaterThan, 0)
+				sent := r.sent[len(r.sent)-1]
+				published := c.named("publish " + sent.Subject)
+				So(len(published), ShouldEqual, 1)
+				So(published[0].SpanKind(), ShouldEqual, spanProducer)
+				sc := published[0].SpanContext()
+				So(gjson.GetBytes(sent.Body, "traceparent").String(), ShouldEqual, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01")
+			})
+		})
+	})
+
+	Convey("Given a tracer exporting to a file", t, func() {
+		f, _ := ioutil.TempFile("", "traces")
+		defer os.Remove(f.Name())
+		os.Setenv("TRACING_EXPORTER", "file")
+		os.Setenv("TRACING_FILE", f.Name())
+		defer os.Unsetenv("TRACING_EXPORTER")
+		defer os.Unsetenv("TRACING_FILE")
+		tr, err := loadTracer()
+		So(err, ShouldBeNil)
+
+		Convey("When a span is finished", func() {
+			tr.start("test-generated-id", "store get", spanClient).finish()
+			tr.stop()
+			out, _ := ioutil.ReadFile(f.Name())
+
+			Convey("Then it is written", func() {
+				So(gjson.GetBytes(out, "Name").String(), ShouldEqual, "store get")
+				So(gjson.GetBytes(out, "SpanKind").Int(), ShouldEqual, int(spanClient))
+				So(len(gjson.GetBytes(out, "SpanContext.TraceID").String()), ShouldEqual, 32)
+			})
+		})
+	})
+
+	Convey("Given an unknown tracing exporter", t, func() {
+		os.Setenv("TRACING_EXPORTER", "zipkin")
+		defer os.Unsetenv("TRACING_EXPORTER")
+
+		Convey("Then the tracer is not loaded", func() {
+			_, err := loadTracer()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,237 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Statuses a service can be on without arriving through an arc, services
+// start as created and the error manager moves them to pre-failed
+var entryStatuses = []string{"created", "pre-failed"}
+
+// ValidationIssue : a single problem found on a workflow definition
+type ValidationIssue struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status,omitempty"`
+	Arc     *Arc   `json:"arc,omitempty"`
+}
+
+// ValidationError : all problems found validating a workflow definition
+type ValidationError struct {
+	Issues []ValidationIssue `json:"errors"`
+}
+
+// Error : describes all found issues
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		messages[i] = issue.Message
+	}
+
+	return "Invalid workflow : " + strings.Join(messages, ", ")
+}
+
+func (e *ValidationError) add(code string, message string, status string, a *Arc) {
+	e.Issues = append(e.Issues, ValidationIssue{Code: code, Message: message, Status: status, Arc: a})
+}
+
+// ValidateWorkflow : validates the workflow definition of a service
+func ValidateWorkflow(s *map[string]interface{}) error {
+	w, err := NewWorkflow(s)
+	if err != nil {
+		verr := &ValidationError{}
+		verr.add("invalid_definition", "workflow can't be read : "+err.Error(), "", nil)
+		return verr
+	}
+
+	return w.Validate(s)
+}
+
+// Validate : checks the workflow is able to drive the given service, it
+// returns a *ValidationError with all found issues
+func (w *Workflow) Validate(s *map[string]interface{}) error {
+	verr := &ValidationError{}
+
+	if len(w.Arcs) == 0 {
+		verr.add("empty_workflow", "workflow has no arcs", "", nil)
+		return verr
+	}
+
+	w.validateArcs(s, verr)
+	w.validateStatuses(verr)
+
+	if len(verr.Issues) > 0 {
+		return verr
+	}
+
+	return nil
+}
+
+// validateArcs : checks for duplicated arcs, arcs sending batches not
+// present on the service and the error path
+func (w *Workflow) validateArcs(s *map[string]interface{}, verr *ValidationError) {
+	seen := make(map[[4]string]bool)
+	errorPath := false
+
+	for i := range w.Arcs {
+		a := w.Arcs[i]
+		key := [4]string{a.From, a.Event, a.Condition, a.Branch}
+		if seen[key] {
+			verr.add("duplicate_arc", "event "+a.Event+" is defined twice from "+a.From, a.From, &w.Arcs[i])
+		}
+		seen[key] = true
+
+		if a.Event == "to_error" && a.From == "pre-failed" {
+			errorPath = true
+		}
+
+		if a.Timeout != "" {
+			if d, err := time.ParseDuration(a.Timeout); err != nil || d <= 0 {
+				verr.add("invalid_timeout", "timeout "+a.Timeout+" on "+a.Event+" is not a valid duration", a.From, &w.Arcs[i])
+			}
+			if a.Branch != "" {
+				verr.add("unsupported_timeout", "timeout on "+a.Event+" is not supported on branch "+a.Branch, a.From, &w.Arcs[i])
+			}
+		}
+
+		if a.Retry != nil {
+			if err := a.Retry.validate(); err != nil {
+				verr.add("invalid_retry", "retry policy on "+a.Event+" : "+err.Error(), a.From, &w.Arcs[i])
+			}
+		}
+
+		if a.Compensation != "" && batchKey(a.Compensation) == "" {
+			verr.add("invalid_compensation", "compensation "+a.Compensation+" on "+a.Event+" does not send a batch", a.From, &w.Arcs[i])
+		}
+
+		if batch := batchKey(a.Event); batch != "" {
+			if _, ok := (*s)[batch]; !ok {
+				verr.add("missing_batch", "event "+a.Event+" needs "+batch+" on the service", a.From, &w.Arcs[i])
+			}
+		}
+	}
+
+	if !errorPath {
+		verr.add("missing_error_path", "there is no to_error arc from pre-failed", "pre-failed", nil)
+	}
+}
+
+// validateStatuses : checks all statuses are reachable, there is a terminal
+// status and all branches of a fork join on the same status
+func (w *Workflow) validateStatuses(verr *ValidationError) {
+	reached := make(map[string]bool)
+	pending := append([]string{}, entryStatuses...)
+
+	for len(pending) > 0 {
+		status := pending[0]
+		pending = pending[1:]
+		if reached[status] {
+			continue
+		}
+		reached[status] = true
+		for _, a := range w.Arcs {
+			if a.From == status {
+				pending = append(pending, a.To)
+			}
+		}
+	}
+
+	terminal := false
+	for _, status := range w.statuses() {
+		if !reached[status] {
+			verr.add("unreachable_status", "status "+status+" can't be reached", status, nil)
+		}
+		if w.isTerminal(status) && reached[status] {
+			terminal = true
+		}
+		w.validateJoin(status, verr)
+	}
+
+	if !terminal {
+		verr.add("missing_terminal_status", "there is no reachable terminal status", "", nil)
+	}
+}
+
+// validateJoin : checks all branches starting on a fork end on the same
+// join status
+func (w *Workflow) validateJoin(status string, verr *ValidationError) {
+	var join string
+
+	for _, b := range w.branches(status) {
+		for _, end := range w.branchEnds(b, status) {
+			if join == "" {
+				join = end
+			}
+			if end != join {
+				verr.add("invalid_join", "branches from "+status+" end on "+join+" and "+end, status, nil)
+				return
+			}
+		}
+	}
+}
+
+// statuses : gets all statuses defined on the workflow
+func (w *Workflow) statuses() (statuses []string) {
+	seen := make(map[string]bool)
+	for _, a := range w.Arcs {
+		for _, status := range []string{a.From, a.To} {
+			if !seen[status] {
+				seen[status] = true
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	return statuses
+}
+
+// isTerminal : checks if there are no arcs going out of a status
+func (w *Workflow) isTerminal(status string) bool {
+	for _, a := range w.Arcs {
+		if a.From == status {
+			return false
+		}
+	}
+	return true
+}
+
+// branchEnds : gets the statuses where a branch started on a given status
+// is done
+func (w *Workflow) branchEnds(branch string, status string) (ends []string) {
+	visited := make(map[string]bool)
+	pending := []string{status}
+
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+		if current != status && w.branchDone(branch, current) {
+			ends = append(ends, current)
+			continue
+		}
+		for _, a := range w.Arcs {
+			if a.From == current && a.Branch == branch {
+				pending = append(pending, a.To)
+			}
+		}
+	}
+	return ends
+}
+
+// batchKey : gets the service field holding the components sent by an
+// event, or an empty string if the event does not send a batch
+func batchKey(event string) string {
+	parts := strings.Split(event, ".")
+	if len(parts) != 2 || parts[0] == "service" || parts[1] == "find" {
+		return ""
+	}
+
+	return strings.Replace(event, ".", "_to_", 1)
+}
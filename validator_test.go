@@ -0,0 +1,154 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func issueCodes(err error) (codes []string) {
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		return nil
+	}
+	for _, issue := range verr.Issues {
+		codes = append(codes, issue.Code)
+	}
+	return codes
+}
+
+func TestWorkflowValidation(t *testing.T) {
+	Convey("Given I have a valid service definition", t, func() {
+		s, _ := h.getService("./fixtures/service_components.json")
+
+		Convey("When I validate it", func() {
+			err := ValidateWorkflow(s)
+
+			Convey("Then it has no issues", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When its workflow has a duplicated arc", func() {
+			w, _ := NewWorkflow(s)
+			w.Arcs = append(w.Arcs, Arc{From: "started", To: "other", Event: "components.create"})
+			err := w.Validate(s)
+
+			Convey("Then it is reported", func() {
+				So(issueCodes(err), ShouldContain, "duplicate_arc")
+			})
+		})
+
+		Convey("When its workflow has an unreachable status", func() {
+			w, _ := NewWorkflow(s)
+			w.Arcs = append(w.Arcs, Arc{From: "nowhere", To: "done", Event: "components.create.done"})
+			err := w.Validate(s)
+
+			Convey("Then it is reported", func() {
+				So(issueCodes(err), ShouldContain, "unreachable_status")
+			})
+		})
+
+		Convey("When a batch sent by the workflow is not present", func() {
+			delete(*s, "components_to_update")
+			err := ValidateWorkflow(s)
+
+			Convey("Then it is reported", func() {
+				So(issueCodes(err), ShouldResemble, []string{"missing_batch"})
+			})
+		})
+
+		Convey("When its workflow has no error path", func() {
+			w, _ := NewWorkflow(s)
+			var arcs []Arc
+			for _, a := range w.Arcs {
+				if a.Event != "to_error" {
+					arcs = append(arcs, a)
+				}
+			}
+			w.Arcs = arcs
+			err := w.Validate(s)
+
+			Convey("Then it is reported", func() {
+				So(issueCodes(err), ShouldContain, "missing_error_path")
+			})
+		})
+
+		Convey("When its workflow has no terminal status", func() {
+			w, _ := NewWorkflow(s)
+			w.Arcs = append(w.Arcs,
+				Arc{From: "done", To: "created", Event: "service.restart"},
+				Arc{From: "errored", To: "created", Event: "service.retry"},
+			)
+			err := w.Validate(s)
+
+			Convey("Then it is reported", func() {
+				So(issueCodes(err), ShouldContain, "missing_terminal_status")
+			})
+		})
+	})
+
+	Convey("Given I have a service definition with parallel branches", t, func() {
+		s, _ := h.getService("./fixtures/service_fork.json")
+		(*s)["networks_to_create"] = map[string]interface{}{}
+		(*s)["firewalls_to_create"] = map[string]interface{}{}
+		(*s)["firewalls_to_update"] = map[string]interface{}{}
+		(*s)["instances_to_create"] = map[string]interface{}{}
+
+		Convey("When I validate it", func() {
+			err := ValidateWorkflow(s)
+
+			Convey("Then it has no issues", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When its branches end on different statuses", func() {
+			w, _ := NewWorkflow(s)
+			w.Arcs[2].To = "networks_created"
+			w.Arcs = append(w.Arcs, Arc{From: "networks_created", To: "done", Event: "networks.done"})
+			err := w.Validate(s)
+
+			Convey("Then it is reported", func() {
+				So(issueCodes(err), ShouldContain, "invalid_join")
+			})
+		})
+
+		Convey("When a branch arc defines a timeout", func() {
+			w, _ := NewWorkflow(s)
+			w.Arcs[2].Timeout = "10m"
+			err := w.Validate(s)
+
+			Convey("Then it is reported", func() {
+				So(issueCodes(err), ShouldContain, "unsupported_timeout")
+			})
+		})
+	})
+}
+
+func TestInvalidServiceCreation(t *testing.T) {
+	Convey("Given I receive a service.create with an invalid workflow", t, func() {
+		setup()
+
+		p.load(natsClient)
+		body := []byte(`{"id":"test-invalid-id","workflow":{"arcs":[{"from":"created","to":"started","event":"service.create"}]}}`)
+		mapping := map[string]interface{}{}
+		_ = json.Unmarshal(body, &mapping)
+		SaveService(&mapping)
+
+		Convey("When I get the service from the message", func() {
+			mm := MessageManager{}
+			s, _, err := mm.getServiceFromMessage("service.create", body)
+
+			Convey("Then I receive the validation error", func() {
+				So(s["id"], ShouldEqual, "test-invalid-id")
+				So(issueCodes(err), ShouldContain, "missing_error_path")
+			})
+		})
+	})
+}
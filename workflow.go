@@ -7,6 +7,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"log"
 )
 
 // Workflow : object is a representation for the json that represents the
@@ -17,30 +18,46 @@ type Workflow struct {
 
 // Arc : or transition is the definition of an event that happens when the
 // service on a status "from" receives an "event" and becomes on status
-// "to"
+// "to". An arc can be guarded by a condition evaluated against the
+// service, so it is only followed when the condition holds.
+//
+// Arcs can belong to a branch: when the service reaches a status with
+// branch arcs going out of it, all branches are started at once and each
+// one moves on its own status. Once every branch reaches a status with
+// no more arcs on the branch, the join status, the service moves to it.
+//
+// An arc can also define a timeout, the service will be failed if it
+// stays longer than it on the status the arc leads to, a retry policy
+// for the batch sent by its event and the compensation event undoing
+// the components created by that batch when the service fails
 type Arc struct {
-	From  string `json:"from"`
-	To    string `json:"to"`
-	Event string `json:"event"`
+	From         string       `json:"from"`
+	To           string       `json:"to"`
+	Event        string       `json:"event"`
+	Condition    string       `json:"condition,omitempty"`
+	Branch       string       `json:"branch,omitempty"`
+	Timeout      string       `json:"timeout,omitempty"`
+	Retry        *RetryPolicy `json:"retry,omitempty"`
+	Compensation string       `json:"compensation,omitempty"`
 }
 
 // NewWorkflow : generates a workflow based on the input service
 func NewWorkflow(s *map[string]interface{}) (Workflow, error) {
 	var ser service
 
-	raw, _ := json.Marshal(s)
+	raw, _ := json.Marshal(map[string]interface{}{"workflow": (*s)["workflow"]})
 	err := json.Unmarshal(raw, &ser)
 
 	return ser.Workflow, err
 }
 
 // nextArc : Get next arc for the current workflow definition for a given status and
-// event
-func (w *Workflow) nextArc(status string, event string) (*Arc, error) {
+// event, whose condition holds for the given service data
+func (w *Workflow) nextArc(status string, event string, data string) (*Arc, error) {
 	var a = &Arc{}
 	var err = errors.New("No arcs matching your request")
 	for i := 0; i < len(w.Arcs); i++ {
-		if event == w.Arcs[i].Event && w.Arcs[i].From == status {
+		if event == w.Arcs[i].Event && w.Arcs[i].From == status && w.Arcs[i].Branch == "" && w.Arcs[i].holds(data) {
 			a = &w.Arcs[i]
 			err = nil
 			break
@@ -50,20 +67,91 @@ func (w *Workflow) nextArc(status string, event string) (*Arc, error) {
 	return a, err
 }
 
+// nextBranchArc : Get next arc on a branch for a given branch status and event
+func (w *Workflow) nextBranchArc(branch string, status string, event string, data string) (*Arc, error) {
+	for i := 0; i < len(w.Arcs); i++ {
+		if event == w.Arcs[i].Event && w.Arcs[i].From == status && w.Arcs[i].Branch == branch && w.Arcs[i].holds(data) {
+			return &w.Arcs[i], nil
+		}
+	}
+
+	return nil, errors.New("No arcs matching your request")
+}
+
 // nextEvent : Get next event for the current workflow definition for a given status
-func (w *Workflow) nextEvent(status string) (string, error) {
+// and service data
+func (w *Workflow) nextEvent(status string, data string) (string, error) {
 	for i := 0; i < len(w.Arcs); i++ {
-		if w.Arcs[i].From == status {
+		if w.Arcs[i].From == status && w.Arcs[i].Branch == "" && w.Arcs[i].holds(data) {
 			return w.Arcs[i].Event, nil
 		}
 	}
 	return "", errors.New("No new event defined")
 }
 
-// transitions : gets all the events on current workflow
+// nextBranchEvent : Get next event on a branch for a given branch status
+func (w *Workflow) nextBranchEvent(branch string, status string, data string) (string, error) {
+	for i := 0; i < len(w.Arcs); i++ {
+		if w.Arcs[i].From == status && w.Arcs[i].Branch == branch && w.Arcs[i].holds(data) {
+			return w.Arcs[i].Event, nil
+		}
+	}
+	return "", errors.New("No new event defined")
+}
+
+// branches : gets the branches starting on a given status, a status with
+// branches is a fork
+func (w *Workflow) branches(status string) (branches []string) {
+	seen := make(map[string]bool)
+	for _, a := range w.Arcs {
+		if a.From == status && a.Branch != "" && !seen[a.Branch] {
+			seen[a.Branch] = true
+			branches = append(branches, a.Branch)
+		}
+	}
+	return branches
+}
+
+// branchDone : checks if a branch has reached a status with no more arcs on
+// the branch
+func (w *Workflow) branchDone(branch string, status string) bool {
+	for _, a := range w.Arcs {
+		if a.From == status && a.Branch == branch {
+			return false
+		}
+	}
+	return true
+}
+
+// holds : checks if the arc condition holds for the given service data,
+// arcs with invalid conditions are never followed
+func (a *Arc) holds(data string) bool {
+	ok, err := evalCondition(data, a.Condition)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return ok
+}
+
+// retryPolicy : gets the retry policy defined for an event
+func (w *Workflow) retryPolicy(event string) *RetryPolicy {
+	for _, a := range w.Arcs {
+		if a.Event == event && a.Retry != nil {
+			return a.Retry
+		}
+	}
+	return nil
+}
+
+// transitions : gets all the events on current workflow, including the
+// compensation events and their results
 func (w *Workflow) transitions() (transitions []string) {
 	for _, a := range w.Arcs {
 		transitions = append(transitions, a.Event)
+		if a.Compensation != "" {
+			transitions = append(transitions, a.Compensation, a.Compensation+".done")
+		}
 	}
 	return transitions
 }
@@ -0,0 +1,97 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestForkAndJoin(t *testing.T) {
+	Convey("Given a service with a workflow with parallel branches", t, func() {
+		s, _ := h.getService("./fixtures/service_fork.json")
+
+		Convey("When the service reaches the fork", func() {
+			events, err := em.manageEvents("service.create", s)
+
+			Convey("Then an event is sent for each branch", func() {
+				So(err, ShouldBeNil)
+				So(events, ShouldResemble, []string{"networks.create", "firewalls.create"})
+				So((*s)["status"], ShouldEqual, "started")
+				branches := (*s)["branches"].(map[string]interface{})
+				So(branches["networks"], ShouldEqual, "started")
+				So(branches["firewalls"], ShouldEqual, "started")
+			})
+
+			Convey("And the branch events are sent", func() {
+				So(em.move(s, "networks.create"), ShouldBeNil)
+				So(em.move(s, "firewalls.create"), ShouldBeNil)
+
+				Convey("Then each branch moves on its own status", func() {
+					So((*s)["status"], ShouldEqual, "started")
+					branches := (*s)["branches"].(map[string]interface{})
+					So(branches["networks"], ShouldEqual, "creating_networks")
+					So(branches["firewalls"], ShouldEqual, "creating_firewalls")
+				})
+
+				Convey("And a branch finishes before the others", func() {
+					events, err := em.manageEvents("networks.create.done", s)
+
+					Convey("Then the service waits for the other branches", func() {
+						So(err, ShouldBeNil)
+						So((*s)["status"], ShouldEqual, "started")
+						So(events, ShouldBeEmpty)
+						branches := (*s)["branches"].(map[string]interface{})
+						So(branches["networks"], ShouldEqual, "components_ready")
+					})
+
+					Convey("And all branches finish", func() {
+						events, _ := em.manageEvents("firewalls.create.done", s)
+						So(events, ShouldResemble, []string{"firewalls.update"})
+						So(em.move(s, "firewalls.update"), ShouldBeNil)
+						events, err := em.manageEvents("firewalls.update.done", s)
+
+						Convey("Then the service moves to the join status", func() {
+							So(err, ShouldBeNil)
+							So((*s)["status"], ShouldEqual, "components_ready")
+							So((*s)["branches"], ShouldBeNil)
+							So(events, ShouldResemble, []string{"instances.create"})
+						})
+					})
+				})
+
+				Convey("And a branch result is processed while the other is in flight", func() {
+					r := &recorder{}
+					defer sandbox(r)()
+					(*s)["networks"] = map[string]interface{}{"items": []interface{}{}}
+					SaveService(s)
+					err := processInputMessage(&nats.Msg{
+						Subject: "networks.create.done",
+						Data:    []byte(`{"service":"test-fork-id","components":[]}`),
+					})
+					stored := p.getService("test-fork-id")
+
+					Convey("Then nothing is sent for the branch in flight", func() {
+						So(err, ShouldBeNil)
+						So(sentSubjects(r), ShouldBeEmpty)
+						So(stored["held_events"], ShouldBeNil)
+						So(stored["branches"].(map[string]interface{})["networks"], ShouldEqual, "components_ready")
+					})
+				})
+
+				Convey("And an unexpected branch event is received", func() {
+					_, err := em.manageEvents("firewalls.update.done", s)
+
+					Convey("Then the transition is not valid", func() {
+						So(err, ShouldNotBeNil)
+					})
+				})
+			})
+		})
+	})
+}